@@ -0,0 +1,314 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// APIKeyHeader identifies the client of a request for rate limiting. A bearer token is used when it isn't set.
+	APIKeyHeader = "X-API-Key"
+	// rateLimitSweepInterval is how often buckets that refilled completely are removed
+	rateLimitSweepInterval = time.Minute
+	// maxRateLimitBuckets caps the buckets between sweeps, the least recently used bucket is evicted when a new one
+	// would exceed it. Clients that rotate their IP or key can't grow the buckets without bound.
+	maxRateLimitBuckets = 100000
+	// maxLimitedBody is the size of the request bodies read to find the workflow that is started
+	maxLimitedBody = 1 << 20
+)
+
+// rateLimitMetrics counts allowed and limited requests per dimension, like "ip_limited", the number of buckets
+// and the evicted buckets. They are published as expvar and served under /debug/ratelimit. The API doesn't serve all expvars,
+// they include the command line with its secrets.
+var rateLimitMetrics = expvar.NewMap("ratelimit")
+
+// RateLimit is a token bucket that allows Rate requests per second with bursts of up to Burst requests
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimits configures the limits per client identity. Zero limits don't limit.
+type RateLimits struct {
+	// APIKey limits the requests per API key, requests without key are only limited by IP
+	APIKey RateLimit
+	// IP limits the requests per source IP. The address of the connection is used, td doesn't trust
+	// X-Forwarded-For.
+	IP RateLimit
+	// Workflow limits the starts per workflow, across clients. Status requests aren't limited per workflow, as
+	// Dispatch polls every open run.
+	Workflow RateLimit
+}
+
+// enabled reports whether any limit is set
+func (l RateLimits) enabled() bool {
+	return l.APIKey.Rate > 0 || l.IP.Rate > 0 || l.Workflow.Rate > 0
+}
+
+// ParseRateLimits parses a comma separated list of dimension=limit pairs, like ip=5/s,key=300/m:50,workflow=1/s.
+// A limit is a number of requests per s, m or h with an optional burst, which defaults to the number of requests.
+func ParseRateLimits(s string) (RateLimits, error) {
+	var limits RateLimits
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return limits, fmt.Errorf("invalid rate limit %q, expected dimension=requests/unit", pair)
+		}
+		limit, err := parseRateLimit(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return limits, fmt.Errorf("invalid rate limit %q: %w", pair, err)
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "key":
+			limits.APIKey = limit
+		case "ip":
+			limits.IP = limit
+		case "workflow":
+			limits.Workflow = limit
+		default:
+			return limits, fmt.Errorf("invalid rate limit %q, the dimension is key, ip or workflow", pair)
+		}
+	}
+	return limits, nil
+}
+
+func parseRateLimit(s string) (RateLimit, error) {
+	rate, burst := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		rate, burst = s[:i], s[i+1:]
+	}
+	parts := strings.SplitN(rate, "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("expected requests/unit")
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil || n <= 0 {
+		return RateLimit{}, fmt.Errorf("the number of requests must be positive")
+	}
+	units := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+	unit, ok := units[parts[1]]
+	if !ok {
+		return RateLimit{}, fmt.Errorf("the unit is s, m or h")
+	}
+	limit := RateLimit{Rate: float64(n) / unit.Seconds(), Burst: n}
+	if burst != "" {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return RateLimit{}, fmt.Errorf("the burst must be positive")
+		}
+	}
+	return limit, nil
+}
+
+// bucket holds the tokens of one client identity
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+	limit  RateLimit
+	// used is the element of the bucket in the list of recently used buckets
+	used *list.Element
+}
+
+// refill adds the tokens for the time since the last request
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+	b.last = now
+}
+
+// wait returns the time until the bucket has a token
+func (b *bucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// limiter keeps a token bucket per API key, source IP and workflow
+type limiter struct {
+	limits RateLimits
+	now    func() time.Time
+
+	maxBuckets int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// used orders the buckets from the most to the least recently used
+	used      *list.List
+	lastSweep time.Time
+}
+
+func newLimiter(limits RateLimits) *limiter {
+	return &limiter{limits: limits, now: time.Now, maxBuckets: maxRateLimitBuckets, buckets: map[string]*bucket{}, used: list.New()}
+}
+
+// identity is a bucket a request takes a token from
+type identity struct {
+	dimension string
+	id        string
+	limit     RateLimit
+}
+
+// take takes a token from the buckets of all identities, or from none of them. When a bucket is empty it returns
+// the time until all buckets have a token.
+func (l *limiter) take(identities []identity) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	var wait time.Duration
+	buckets := make([]*bucket, len(identities))
+	for i, ident := range identities {
+		key := ident.dimension + ":" + ident.id
+		b, ok := l.buckets[key]
+		if ok {
+			l.used.MoveToFront(b.used)
+		} else {
+			b = &bucket{key: key, tokens: float64(ident.limit.Burst), last: now, limit: ident.limit}
+			b.used = l.used.PushFront(b)
+			l.buckets[key] = b
+		}
+		b.refill(now)
+		buckets[i] = b
+		if w := b.wait(); w > 0 {
+			rateLimitMetrics.Add(ident.dimension+"_limited", 1)
+			if w > wait {
+				wait = w
+			}
+		}
+	}
+	l.evict(len(identities))
+	rateLimitMetrics.Set("buckets", expvarInt(len(l.buckets)))
+	if wait > 0 {
+		return false, wait
+	}
+	for i, b := range buckets {
+		b.tokens--
+		rateLimitMetrics.Add(identities[i].dimension+"_allowed", 1)
+	}
+	return true, 0
+}
+
+// sweep removes the buckets that refilled completely, they are created again on the next request
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for _, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			l.remove(b)
+		}
+	}
+}
+
+// evict removes the least recently used buckets over the maximum, but keeps the keep most recently used buckets of
+// the request
+func (l *limiter) evict(keep int) {
+	for len(l.buckets) > l.maxBuckets && len(l.buckets) > keep {
+		l.remove(l.used.Back().Value.(*bucket))
+		rateLimitMetrics.Add("evicted", 1)
+	}
+}
+
+// remove deletes the bucket, it is created again on the next request
+func (l *limiter) remove(b *bucket) {
+	l.used.Remove(b.used)
+	delete(l.buckets, b.key)
+}
+
+// identities returns the buckets of the request
+func (l *limiter) identities(r *http.Request) []identity {
+	var identities []identity
+	if l.limits.APIKey.Rate > 0 {
+		if key := apiKey(r); key != "" {
+			identities = append(identities, identity{dimension: "key", id: key, limit: l.limits.APIKey})
+		}
+	}
+	if l.limits.IP.Rate > 0 {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		identities = append(identities, identity{dimension: "ip", id: ip, limit: l.limits.IP})
+	}
+	if l.limits.Workflow.Rate > 0 {
+		if workflow := requestWorkflow(r); workflow != "" {
+			identities = append(identities, identity{dimension: "workflow", id: workflow, limit: l.limits.Workflow})
+		}
+	}
+	return identities
+}
+
+// apiKey returns the API key of the request from the API key header or the bearer token
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// requestWorkflow returns the workflow a request starts, from its body. The body is restored for the handler.
+func requestWorkflow(r *http.Request) string {
+	if r.Method != http.MethodPost || r.Body == nil {
+		return ""
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxLimitedBody))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	req := &workflowRunRequest{}
+	if json.Unmarshal(body, req) != nil {
+		return ""
+	}
+	return req.WorkflowID
+}
+
+// rateLimited rejects requests over the limits of their client identity with 429 Too Many Requests
+func rateLimited(l *limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.take(l.identities(r))
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitMetricsHandler serves the metrics of the rate limiter as JSON. It is only mounted when rate limits are
+// enabled, behind the limiter.
+func rateLimitMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(rateLimitMetrics.String()))
+	})
+}
+
+// expvarInt returns n as expvar.Var
+func expvarInt(n int) expvar.Var {
+	v := new(expvar.Int)
+	v.Set(int64(n))
+	return v
+}
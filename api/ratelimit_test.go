@@ -0,0 +1,139 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("ip=5/s, key=300/m:50,workflow=2/h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.IP != (RateLimit{Rate: 5, Burst: 5}) {
+		t.Errorf("ip limit is %+v", limits.IP)
+	}
+	if limits.APIKey != (RateLimit{Rate: 5, Burst: 50}) {
+		t.Errorf("key limit is %+v", limits.APIKey)
+	}
+	if limits.Workflow.Burst != 2 || limits.Workflow.Rate*3600 != 2 {
+		t.Errorf("workflow limit is %+v", limits.Workflow)
+	}
+
+	for _, invalid := range []string{"ip", "ip=5", "ip=5/d", "ip=0/s", "ip=5/s:0", "user=5/s"} {
+		if _, err := ParseRateLimits(invalid); err == nil {
+			t.Errorf("ParseRateLimits(%q) accepted an invalid limit", invalid)
+		}
+	}
+}
+
+// limitedServer returns a rate limited handler that checks that starts still have their body, and its clock
+func limitedServer(limits RateLimits) (http.Handler, *time.Time) {
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := newLimiter(limits)
+	l.now = func() time.Time { return now }
+	return rateLimited(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && requestWorkflow(r) == "" {
+			http.Error(w, "body was not restored", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})), &now
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimitIP(t *testing.T) {
+	h, now := limitedServer(RateLimits{IP: RateLimit{Rate: 0.5, Burst: 2}})
+	get := func(ip string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/workflow/?workflow_id=random_dog", nil)
+		r.RemoteAddr = ip + ":41000"
+		return serve(h, r)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := get("10.0.0.1"); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst got %d", i, w.Code)
+		}
+	}
+	w := get("10.0.0.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After is %q, want 2", got)
+	}
+	if w := get("10.0.0.2"); w.Code != http.StatusOK {
+		t.Errorf("another IP got %d", w.Code)
+	}
+
+	*now = now.Add(2 * time.Second)
+	if w := get("10.0.0.1"); w.Code != http.StatusOK {
+		t.Errorf("request after Retry-After got %d", w.Code)
+	}
+}
+
+func TestRateLimitAPIKeyAndWorkflow(t *testing.T) {
+	h, _ := limitedServer(RateLimits{APIKey: RateLimit{Rate: 1, Burst: 1}, Workflow: RateLimit{Rate: 1, Burst: 2}})
+	start := func(key, workflow string) int {
+		r := httptest.NewRequest(http.MethodPost, "/workflow/", strings.NewReader(`{"workflow_id":"`+workflow+`"}`))
+		r.Header.Set("Authorization", "Bearer "+key)
+		return serve(h, r).Code
+	}
+
+	if code := start("dispatch", "random_dog"); code != http.StatusOK {
+		t.Fatalf("first start got %d", code)
+	}
+	if code := start("dispatch", "random_unsplash"); code != http.StatusTooManyRequests {
+		t.Errorf("second start with the same key got %d", code)
+	}
+	if code := start("script", "random_dog"); code != http.StatusOK {
+		t.Errorf("start with another key got %d", code)
+	}
+	if code := start("cron", "random_dog"); code != http.StatusTooManyRequests {
+		t.Errorf("third start of the workflow got %d", code)
+	}
+	// the rejected start didn't take a token of its key
+	if code := start("cron", "random_unsplash"); code != http.StatusOK {
+		t.Errorf("start of another workflow got %d", code)
+	}
+	if !strings.Contains(rateLimitMetrics.String(), `"workflow_limited"`) {
+		t.Errorf("limited starts are not counted: %s", rateLimitMetrics.String())
+	}
+}
+
+func TestRateLimitEvictsBuckets(t *testing.T) {
+	l := newLimiter(RateLimits{IP: RateLimit{Rate: 0.5, Burst: 1}})
+	l.maxBuckets = 2
+	take := func(ip string) bool {
+		ok, _ := l.take([]identity{{dimension: "ip", id: ip, limit: l.limits.IP}})
+		return ok
+	}
+
+	take("10.0.0.1")
+	take("10.0.0.2")
+	if take("10.0.0.2") {
+		t.Fatal("the second request of 10.0.0.2 was allowed")
+	}
+	take("10.0.0.3")
+	if len(l.buckets) != 2 || l.used.Len() != 2 {
+		t.Fatalf("%d buckets are kept, want 2", len(l.buckets))
+	}
+	// the bucket of 10.0.0.1 was evicted for 10.0.0.3, it starts full again and evicts the bucket of 10.0.0.2
+	if !take("10.0.0.1") {
+		t.Error("the evicted bucket of 10.0.0.1 is still empty")
+	}
+	if _, ok := l.buckets["ip:10.0.0.2"]; ok {
+		t.Error("the bucket of 10.0.0.2 was kept, although 10.0.0.1 and 10.0.0.3 were used after it")
+	}
+	if !strings.Contains(rateLimitMetrics.String(), `"evicted"`) {
+		t.Errorf("evicted buckets are not counted: %s", rateLimitMetrics.String())
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// streamPollInterval is how often the stream queries the state of the workflow
+	streamPollInterval = time.Second
+	// streamHeartbeatInterval is how often the stream sends a comment to keep idle connections open
+	streamHeartbeatInterval = 15 * time.Second
+	// streamRetry is the reconnection delay in milliseconds the stream asks clients to use
+	streamRetry = 3000
+)
+
+// streamEndpoint serves GET /workflow/stream. It pushes the state of a workflow as server-sent events until the
+// workflow closes. The id of an event is a checksum of the state, a client that reconnects with Last-Event-ID only
+// gets the state again when it changed in the meantime.
+type streamEndpoint struct {
+	query       func(workflowID, instanceID string) (update *schema.WorkflowInstanceUpdate, closed bool, err error)
+	version     versions.Adapter
+	legacyTimes bool
+	poll        time.Duration
+	heartbeat   time.Duration
+}
+
+// ServeHTTP is satisfies the http.Handler interface to serve requests
+func (h *streamEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		notFound(w, r)
+		return
+	}
+	version, err := requestVersion(r, h.version, h.legacyTimes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	workflowID := r.URL.Query().Get("workflow_id")
+	instanceID := r.URL.Query().Get("workflow_instance_id")
+
+	// the first query happens before the stream starts, so unknown workflows get a 404
+	update, closed, err := h.query(workflowID, instanceID)
+	if err == workflows.ErrWorkflowNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Unable to query workflow", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("x-accel-buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	flusher.Flush()
+
+	poll := time.NewTicker(h.poll)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	lastID := r.Header.Get("Last-Event-ID")
+	for {
+		data, err := version.Encode(update)
+		if err != nil {
+			log.Println("Unable to encode workflow update", err)
+			return
+		}
+		if id := eventID(data); id != lastID {
+			fmt.Fprintf(w, "id: %s\nevent: state\ndata: %s\n\n", id, data)
+			flusher.Flush()
+			lastID = id
+		}
+		// the status of a run can still change until its execution closed, like a saga that compensates
+		if closed {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			// the state didn't change, so nothing but the heartbeat is sent
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+			continue
+		case <-poll.C:
+		}
+
+		if update, closed, err = h.query(workflowID, instanceID); err != nil {
+			log.Println("Unable to query workflow", err)
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", strings.ReplaceAll(err.Error(), "\n", " "))
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// eventID returns the checksum of the encoded state
+func eventID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bufio"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type event struct {
+	id, name, data string
+}
+
+// streamStates returns a stream endpoint that returns the states one query after the other. The execution closes
+// with the last state.
+func streamStates(states ...*schema.WorkflowInstanceUpdate) *httptest.Server {
+	latest, _ := versions.Lookup(versions.Latest)
+	queries := 0
+	return httptest.NewServer(&streamEndpoint{
+		query: func(workflowID, instanceID string) (*schema.WorkflowInstanceUpdate, bool, error) {
+			if workflowID != "random_dog" || instanceID != "25" {
+				return nil, false, workflows.ErrWorkflowNotFound
+			}
+			i := queries
+			if queries < len(states)-1 {
+				queries++
+			}
+			return states[i], i == len(states)-1, nil
+		},
+		version:   latest,
+		poll:      time.Millisecond,
+		heartbeat: time.Hour,
+	})
+}
+
+// readEvents reads the events of the stream until it closes
+func readEvents(t *testing.T, url, lastEventID string) []event {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url+"?workflow_id=random_dog&workflow_instance_id=25", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("content-type"); ct != "text/event-stream" {
+		t.Fatalf("content type is %q", ct)
+	}
+
+	var events []event
+	var e event
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if e.name != "" {
+				events = append(events, e)
+			}
+			e = event{}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return events
+}
+
+func TestStream(t *testing.T) {
+	running := &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusRunning, Artifacts: []*schema.DocumentCreate{}}
+	fetched := &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusRunning, Artifacts: []*schema.DocumentCreate{{Name: "dog.jpg"}}}
+	completed := &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusCompleted, Artifacts: []*schema.DocumentCreate{{Name: "dog.jpg"}}}
+
+	srv := streamStates(running, running, fetched, fetched, completed)
+	defer srv.Close()
+
+	events := readEvents(t, srv.URL, "")
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want one per change: %v", len(events), events)
+	}
+	for i, want := range []string{`"status":"Running"`, `"dog.jpg"`, `"status":"Completed"`} {
+		if events[i].name != "state" || !strings.Contains(events[i].data, want) {
+			t.Errorf("event %d is %+v, want %s", i, events[i], want)
+		}
+	}
+	if events[0].id == events[1].id {
+		t.Error("events of different states have the same id")
+	}
+}
+
+func TestStreamCompensation(t *testing.T) {
+	// a saga reports Failed as soon as a step failed, the stream stays open while it compensates
+	failed := &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusFailed, RunReason: schema.String("compensating"), Artifacts: []*schema.DocumentCreate{}}
+	compensated := &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusFailed, RunReason: schema.String("compensated"), Artifacts: []*schema.DocumentCreate{}}
+
+	srv := streamStates(failed, failed, compensated)
+	defer srv.Close()
+
+	events := readEvents(t, srv.URL, "")
+	if len(events) != 2 || !strings.Contains(events[1].data, `"run_reason":"compensated"`) {
+		t.Fatalf("got events %v, want the state after the compensation", events)
+	}
+}
+
+func TestStreamLastEventID(t *testing.T) {
+	completed := &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusCompleted, Artifacts: []*schema.DocumentCreate{}}
+	srv := streamStates(completed)
+	defer srv.Close()
+
+	events := readEvents(t, srv.URL, "")
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if again := readEvents(t, srv.URL, events[0].id); len(again) != 0 {
+		t.Errorf("the state is sent again after reconnecting with its id: %v", again)
+	}
+}
+
+func TestStreamNotFound(t *testing.T) {
+	srv := streamStates(&schema.WorkflowInstanceUpdate{})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?workflow_id=random_cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status is %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestWithoutWriteTimeout(t *testing.T) {
+	srv := httptest.NewUnstartedServer(withoutWriteTimeout(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})))
+	srv.Config.WriteTimeout = 20 * time.Millisecond
+	srv.Config.ConnContext = withConn
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("response was cut off by the write timeout: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Errorf("got %q", body)
+	}
+}
@@ -3,32 +3,67 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/artifacts"
 	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
 	"github.com/jtorvald/temporal-dispatch-poc/workflows"
 	"log"
+	"net"
 	"net/http"
-	"reflect"
-	"strings"
 	"time"
 )
 
-type workflowEndpoint struct {
-	workflowClient *workflows.WorkflowClient
+// Options configures the API server
+type Options struct {
+	// Addr is the interface and port to listen on
+	Addr string
+	// DispatchVersion selects the shape of the responses for requests without a version header.
+	// Defaults to versions.Latest.
+	DispatchVersion string
+	// LegacyTimes sends timestamps without time zone, in schema.LegacyDateTimeFormat, for older Dispatch versions
+	LegacyTimes bool
+	// Artifacts are served under /artifacts/ to requests with a link signed by ArtifactLinks when both are set
+	Artifacts     artifacts.Store
+	ArtifactLinks *artifacts.Links
+	// RateLimits limit the requests to the workflow endpoints per client identity. Zero limits don't limit.
+	RateLimits RateLimits
 }
 
-const format = "2006-01-02 15:04:05"
+// workflowClient is the part of workflows.WorkflowClient the endpoints use, it is replaced in tests
+type workflowClient interface {
+	Start(workflowID string, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error)
+	Query(workflowID, instanceID string) (*schema.WorkflowInstanceUpdate, error)
+	Progress(workflowID, instanceID string) (*workflows.Progress, error)
+	Cancel(workflowID, instanceID string) error
+}
+
+type workflowEndpoint struct {
+	workflowClient workflowClient
+	version        versions.Adapter
+	legacyTimes    bool
+}
 
 // ServeHTTP is satisfies the http.Handler interface to serve requests
 func (h *workflowEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
+	if _, err := h.adapter(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/workflow/progress":
+		h.GetWorkflowProgress(w, r)
+		return
 	case r.Method == http.MethodGet:
 		h.GetWorkflowStatus(w, r)
 		return
 	case r.Method == http.MethodPost:
 		h.PostRunWorkflow(w, r)
 		return
+	case r.Method == http.MethodDelete:
+		h.DeleteWorkflow(w, r)
+		return
 	default:
 		notFound(w, r)
 		return
@@ -37,19 +72,49 @@ func (h *workflowEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // ListenAndServe starts a http server in the background that listens for api calls to start a workflow or request
 // workflow status
-func ListenAndServe(ctx context.Context, addr string, workflowStarter *workflows.WorkflowClient) {
-	mux := http.NewServeMux()
-	mux.Handle("/workflow/", &workflowEndpoint{
+func ListenAndServe(ctx context.Context, options Options, workflowStarter *workflows.WorkflowClient) error {
+	version, err := versions.Lookup(options.DispatchVersion)
+	if err != nil {
+		return err
+	}
+	if options.LegacyTimes {
+		version = versions.WithLegacyTimes(version)
+	}
+
+	// starts finish within this timeout, workflows.Start answers with the state it reached before its budget ends
+	var endpoint http.Handler = http.TimeoutHandler(&workflowEndpoint{
 		workflowClient: workflowStarter,
-	})
+		version:        version,
+		legacyTimes:    options.LegacyTimes,
+	}, 4*time.Second, "timeout")
+	// the stream stays open until the workflow closes, so it isn't wrapped in the timeout of the other endpoints
+	var stream http.Handler = &streamEndpoint{
+		query:       workflowStarter.QueryExecution,
+		version:     version,
+		legacyTimes: options.LegacyTimes,
+		poll:        streamPollInterval,
+		heartbeat:   streamHeartbeatInterval,
+	}
+	mux := http.NewServeMux()
+	if options.RateLimits.enabled() {
+		l := newLimiter(options.RateLimits)
+		endpoint, stream = rateLimited(l, endpoint), rateLimited(l, stream)
+		mux.Handle("/debug/ratelimit", rateLimited(l, rateLimitMetricsHandler()))
+	}
+	mux.Handle("/workflow/", endpoint)
+	mux.Handle("/workflow/stream", withoutWriteTimeout(stream))
+	if options.Artifacts != nil && options.ArtifactLinks != nil {
+		mux.Handle("/artifacts/", withoutWriteTimeout(artifacts.Handler(options.Artifacts, options.ArtifactLinks)))
+	}
 
 	srv := &http.Server{
-		Addr:              addr,
+		Addr:              options.Addr,
 		ReadTimeout:       4 * time.Second,
 		WriteTimeout:      4 * time.Second,
 		IdleTimeout:       30 * time.Second,
 		ReadHeaderTimeout: 2 * time.Second,
 		Handler:           mux,
+		ConnContext:       withConn,
 	}
 	srv.SetKeepAlivesEnabled(true)
 
@@ -59,7 +124,7 @@ func ListenAndServe(ctx context.Context, addr string, workflowStarter *workflows
 		}
 	}()
 
-	log.Print("Server Started and listening on ", addr)
+	log.Print("Server Started and listening on ", options.Addr)
 
 	go func() {
 		<-ctx.Done()
@@ -78,6 +143,28 @@ func ListenAndServe(ctx context.Context, addr string, workflowStarter *workflows
 			log.Fatalf("Server Shutdown Failed:%+v", err)
 		}
 	}()
+	return nil
+}
+
+type connKey struct{}
+
+// withConn keeps the connection of the server in the context of its requests, for handlers that change its deadlines
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// withoutWriteTimeout lifts the write timeout of the server for responses that take longer than API calls, like
+// streams and downloads of large artifacts. The server sets the deadline on the connection before it calls the
+// handler, so it is cleared on the connection the server keeps with withConn.
+func withoutWriteTimeout(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, ok := r.Context().Value(connKey{}).(net.Conn); !ok {
+			log.Println("Unable to lift the write timeout, the server doesn't keep the connection")
+		} else if err := c.SetWriteDeadline(time.Time{}); err != nil {
+			log.Println("Unable to lift the write timeout", err)
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // GetWorkflowStatus is one of the request/response handlers and is responsible for
@@ -91,21 +178,42 @@ func (h *workflowEndpoint) GetWorkflowStatus(w http.ResponseWriter, r *http.Requ
 	log.Println("Query workflow params:", r.URL.Query())
 
 	// Query params:  workflow_id, workflow_instance_id, incident_id and incident_name.
-	result := h.workflowClient.Query(workflowID, instanceID)
-	if result == nil {
+	result, err := h.workflowClient.Query(workflowID, instanceID)
+	if err == workflows.ErrWorkflowNotFound {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	m := workflowInstanceUpdateToMap(result)
-
-	bts, err := json.Marshal(m)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Println("Unable to query workflow", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.writeUpdate(w, r, result)
+}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write(bts)
+// GetWorkflowProgress returns the state of a workflow in the shape of the Dispatch version of the request,
+// together with the steps of the run
+func (h *workflowEndpoint) GetWorkflowProgress(w http.ResponseWriter, r *http.Request) {
+	workflowID := r.URL.Query().Get("workflow_id")
+	instanceID := r.URL.Query().Get("workflow_instance_id")
+
+	result, err := h.workflowClient.Query(workflowID, instanceID)
+	if err == workflows.ErrWorkflowNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Unable to query workflow", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	progress, err := h.workflowClient.Progress(workflowID, instanceID)
+	if err != nil {
+		log.Println("Unable to query workflow progress", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeProgress(w, r, result, progress)
 }
 
 //PostRunWorkflow handles to post request to the api to start a workflow
@@ -132,94 +240,132 @@ func (h *workflowEndpoint) PostRunWorkflow(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	result := h.workflowClient.Start(req.WorkflowID, req.Params)
-	if result == nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// a schedule can be set next to the params or as parameter in Dispatch
+	if req.Params == nil {
+		req.Params = map[string]interface{}{}
+	}
+	if req.Schedule != "" {
+		req.Params["schedule"] = req.Schedule
+	}
+	if req.Interval != "" {
+		req.Params["interval"] = req.Interval
+	}
+
+	result, err := h.workflowClient.Start(req.WorkflowID, req.Params)
+	var invalid *workflows.ValidationError
+	if errors.As(err, &invalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var limit *workflows.LimitError
+	if errors.As(err, &limit) || errors.Is(err, workflows.ErrWorkflowAlreadyStarted) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	m := workflowInstanceUpdateToMap(result)
-	log.Println("mapped start result ", m)
+	h.writeUpdate(w, r, result)
+}
 
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(m)
+// DeleteWorkflow cancels a workflow, scheduled workflows stop after the run in progress
+func (h *workflowEndpoint) DeleteWorkflow(w http.ResponseWriter, r *http.Request) {
+	workflowID := r.URL.Query().Get("workflow_id")
+	instanceID := r.URL.Query().Get("workflow_instance_id")
+
+	err := h.workflowClient.Cancel(workflowID, instanceID)
+	if err == workflows.ErrWorkflowNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		log.Println("Unable to cancel workflow", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(http.StatusAccepted)
 }
 
-func notFound(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotFound)
-	w.Write([]byte("not found"))
+// adapter returns the adapter for the Dispatch version of the request. The version header overrides the
+// configured version.
+func (h *workflowEndpoint) adapter(r *http.Request) (versions.Adapter, error) {
+	return requestVersion(r, h.version, h.legacyTimes)
 }
 
-//workflowRunRequest contains the data that needs to start a workflow
-type workflowRunRequest struct {
-	WorkflowID string                 `json:"workflow_id"`
-	Params     map[string]interface{} `json:"params"`
+// requestVersion returns the adapter selected by the version header of the request, or fallback without header.
+// With legacyTimes the adapter of the header sends timestamps without time zone as well.
+func requestVersion(r *http.Request, fallback versions.Adapter, legacyTimes bool) (versions.Adapter, error) {
+	name := r.Header.Get(versions.Header)
+	if name == "" {
+		return fallback, nil
+	}
+	version, err := versions.Lookup(name)
+	if err != nil || !legacyTimes {
+		return version, err
+	}
+	return versions.WithLegacyTimes(version), nil
 }
 
-// workflowInstanceUpdateToMap returns a map from an workflow instance. This is a little hack to
-// workaround the json schema validation in Dispatch that can't handle empty email values in case there is no
-// evergreen holder.
-func workflowInstanceUpdateToMap(update *schema.WorkflowInstanceUpdate) map[string]interface{} {
-	m := map[string]interface{}{}
-	//long and bored code
-	t := reflect.TypeOf(*update)
-	if t.Kind() == reflect.Struct {
-		for i := 0; i < t.NumField(); i++ {
-			var tag = t.Field(i).Tag.Get("json")
-			v := strings.Split(tag, ",")[0] // use split to ignore tag "options" like omitempty, etc.
+// writeUpdate encodes the update for the Dispatch version of the request
+func (h *workflowEndpoint) writeUpdate(w http.ResponseWriter, r *http.Request, update *schema.WorkflowInstanceUpdate) {
+	version, err := h.adapter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-			r := reflect.ValueOf(update)
-			f := reflect.Indirect(r).FieldByName(t.Field(i).Name)
+	bts, err := version.Encode(update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-			m[v] = f.Interface()
-		}
+	w.WriteHeader(http.StatusOK)
+	w.Write(bts)
+}
 
-		if artifacts, ok := m["artifacts"].([]*schema.DocumentCreate); ok {
-			artifactsAsMap := make([]interface{}, len(artifacts))
-			for i, artifact := range artifacts {
-				artifactsAsMap[i] = artifactToMap(artifact)
-			}
-			m["artifacts"] = artifactsAsMap
-		}
+// writeProgress encodes the update for the Dispatch version of the request with the progress of the run
+func (h *workflowEndpoint) writeProgress(w http.ResponseWriter, r *http.Request, update *schema.WorkflowInstanceUpdate, progress *workflows.Progress) {
+	version, err := h.adapter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	} else {
-		fmt.Println("not a stuct ", t.Kind())
+	state, err := version.Encode(update)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bts, err := json.Marshal(workflowProgressResponse{State: state, Progress: progress})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	return m
+	w.WriteHeader(http.StatusOK)
+	w.Write(bts)
 }
 
-// artifactToMap converts a schema.DocumentCreate object to a map of string interface and removes evergreen fields
-// that have empty values and are not allowed by the JSON schema validation in Dispatch
-func artifactToMap(artifact *schema.DocumentCreate) map[string]interface{} {
-	m := map[string]interface{}{}
-	//long and bored code
-	t := reflect.TypeOf(*artifact)
-	if t.Kind() == reflect.Struct {
-		for i := 0; i < t.NumField(); i++ {
-			var tag = t.Field(i).Tag.Get("json")
-			v := strings.Split(tag, ",")[0] // use split to ignore tag "options" like omitempty, etc.
-
-			r := reflect.ValueOf(artifact)
-			f := reflect.Indirect(r).FieldByName(t.Field(i).Name)
-
-			m[v] = f.Interface()
-		}
+func notFound(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("not found"))
+}
 
-		// remove fields that have bad values according to the json schema
-		if v, ok := m["evergreen"]; ok {
-			if v.(bool) == false {
-				delete(m, "evergreen_last_reminder_at")
-				delete(m, "evergreen_owner")
-				delete(m, "evergreen_reminder_interval")
-			}
-		}
+//workflowRunRequest contains the data that needs to start a workflow
+type workflowRunRequest struct {
+	WorkflowID string                 `json:"workflow_id"`
+	Params     map[string]interface{} `json:"params"`
+	// Schedule is a cron expression to run the workflow on repeatedly
+	Schedule string `json:"schedule,omitempty"`
+	// Interval runs the workflow repeatedly, like "5m"
+	Interval string `json:"interval,omitempty"`
+}
 
-	} else {
-		fmt.Println("not a stuct ", t.Kind())
-	}
-	return m
+// workflowProgressResponse is the response of GET /workflow/progress
+type workflowProgressResponse struct {
+	State    json.RawMessage     `json:"state"`
+	Progress *workflows.Progress `json:"progress"`
 }
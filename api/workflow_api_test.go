@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// startClient answers starts with err
+type startClient struct {
+	workflowClient
+	err error
+}
+
+func (c *startClient) Start(workflowID string, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+	return nil, c.err
+}
+
+// postRun posts the start request body to the endpoint and returns the status code of the response
+func postRun(t *testing.T, client workflowClient, body string) int {
+	t.Helper()
+	latest, _ := versions.Lookup(versions.Latest)
+	h := &workflowEndpoint{workflowClient: client, version: latest}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/workflow/", strings.NewReader(body)))
+	return rec.Code
+}
+
+func TestPostRunWorkflowInvalidSchedule(t *testing.T) {
+	for _, body := range []string{
+		`{"workflow_id": "random_dog", "params": {"instance_id": 25}, "schedule": "every day"}`,
+		`{"workflow_id": "random_dog", "params": {"instance_id": 25}, "interval": "5"}`,
+		`{"workflow_id": "random_dog", "params": {"instance_id": 25}, "interval": "1s"}`,
+	} {
+		// the schedule is checked before Temporal is called
+		if code := postRun(t, &workflows.WorkflowClient{}, body); code != http.StatusBadRequest {
+			t.Errorf("%s: status is %d, want 400", body, code)
+		}
+	}
+}
+
+func TestPostRunWorkflowErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"invalid", &workflows.ValidationError{Err: errors.New("invalid schedule")}, http.StatusBadRequest},
+		{"limited", &workflows.LimitError{Workflow: "random_dog", Reason: "1 of 1 runs for incident 32 are open"}, http.StatusConflict},
+		{"already started", workflows.ErrWorkflowAlreadyStarted, http.StatusConflict},
+		{"already started wrapped", fmt.Errorf("random_dog-25: %w", workflows.ErrWorkflowAlreadyStarted), http.StatusConflict},
+		{"unavailable", errors.New("temporal is down"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		if code := postRun(t, &startClient{err: test.err}, `{"workflow_id": "random_dog"}`); code != test.want {
+			t.Errorf("%s: status is %d, want %d", test.name, code, test.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package artifactstest provides an in memory S3 compatible server to test artifact stores against
+package artifactstest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// S3 is a fake S3 compatible object store with path style buckets. It only accepts requests with a valid AWS
+// Signature Version 4 of the access key and a payload checksum that matches the body. The signature is checked
+// with an implementation of its own, so it catches mistakes of the signer of the store.
+type S3 struct {
+	*httptest.Server
+	// AccessKey is the only access key that is accepted
+	AccessKey string
+	// SecretKey is the secret of the access key
+	SecretKey string
+
+	mu      sync.Mutex
+	objects map[string]object
+}
+
+type object struct {
+	contentType string
+	data        []byte
+}
+
+// NewS3 starts a fake S3 server that accepts requests signed with the access key and secret. Close it when done.
+func NewS3(accessKey, secretKey string) *S3 {
+	s := &S3{AccessKey: accessKey, SecretKey: secretKey, objects: map[string]object{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Object returns the content of the object at path, which is /bucket/key
+func (s *S3) Object(path string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[path]
+	return o.data, ok
+}
+
+func (s *S3) serve(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>"+err.Error()+"</Message></Error>", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sum := sha256.Sum256(data)
+		if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+			http.Error(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.objects[r.URL.Path] = object{contentType: r.Header.Get("content-type"), data: data}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		s.mu.Lock()
+		o, ok := s.objects[r.URL.Path]
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("content-type", o.contentType)
+		w.Write(o.data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// verify checks the AWS Signature Version 4 in the authorization header of the request
+func (s *S3) verify(r *http.Request) error {
+	auth := r.Header.Get("authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return fmt.Errorf("unsupported authorization %q", auth)
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "), ",") {
+		if i := strings.Index(field, "="); i > 0 {
+			fields[strings.TrimSpace(field[:i])] = field[i+1:]
+		}
+	}
+	credential := strings.SplitN(fields["Credential"], "/", 2)
+	if len(credential) != 2 || credential[0] != s.AccessKey {
+		return fmt.Errorf("unknown access key in %q", fields["Credential"])
+	}
+	scope := credential[1]
+	parts := strings.Split(scope, "/")
+	amzDate := r.Header.Get("x-amz-date")
+	if len(parts) != 4 || parts[2] != "s3" || parts[3] != "aws4_request" || !strings.HasPrefix(amzDate, parts[0]) {
+		return fmt.Errorf("invalid credential scope %q for date %q", scope, amzDate)
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	var headers strings.Builder
+	for _, name := range signed {
+		value := strings.Join(r.Header.Values(name), ",")
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	var query []string
+	for key, values := range r.URL.Query() {
+		for _, value := range values {
+			query = append(query, encode(key, true)+"="+encode(value, true))
+		}
+	}
+	sort.Strings(query)
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		encode(r.URL.Path, false),
+		strings.Join(query, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range parts {
+		key = sign(key, part)
+	}
+	want := hex.EncodeToString(sign(key, stringToSign))
+	if !hmac.Equal([]byte(fields["Signature"]), []byte(want)) {
+		return fmt.Errorf("signature does not match, canonical request:\n%s", canonicalRequest)
+	}
+	return nil
+}
+
+func sign(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// encode URI encodes everything but the unreserved characters, and slashes when encodeSlash is set
+func encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		unreserved := 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0
+		if unreserved || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
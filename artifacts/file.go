@@ -0,0 +1,134 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// metaDir is the directory in a FileStore that keeps the content types of the artifacts, it can't be used in keys
+const metaDir = ".meta"
+
+// FileStore keeps artifacts in a directory. The content type given to Put is kept next to the artifacts in
+// metaDir, artifacts without a stored type get the type of the extension of their key.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a store in dir, which is created when it doesn't exist
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Put writes the content to a temporary file first, so a failed upload doesn't leave a partial artifact
+func (s *FileStore) Put(ctx context.Context, key, contentType string, r io.Reader) (*Object, error) {
+	if err := s.validKey(key); err != nil {
+		return nil, err
+	}
+	name := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	contentType = contentTypeOf(key, contentType)
+	if err := s.writeContentType(key, contentType); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return nil, err
+	}
+	return &Object{Key: key, ContentType: contentType, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Open returns the file of the artifact
+func (s *FileStore) Open(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if err := s.validKey(key); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, nil, ErrNotFound
+	}
+	return f, &Object{Key: key, ContentType: contentTypeOf(key, s.readContentType(key)), Size: info.Size()}, nil
+}
+
+// validKey returns an error for keys that are invalid or point into metaDir
+func (s *FileStore) validKey(key string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	if key == metaDir || strings.HasPrefix(key, metaDir+"/") {
+		return fmt.Errorf("invalid artifact key %q", key)
+	}
+	return nil
+}
+
+// metaName returns the file with the content type of the artifact
+func (s *FileStore) metaName(key string) string {
+	return filepath.Join(s.dir, metaDir, filepath.FromSlash(key)+".content-type")
+}
+
+func (s *FileStore) writeContentType(key, contentType string) error {
+	name := s.metaName(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, []byte(contentType), 0644)
+}
+
+// readContentType returns the stored content type of the artifact, or an empty string when there is none
+func (s *FileStore) readContentType(key string) string {
+	b, err := ioutil.ReadFile(s.metaName(key))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// contentTypeOf returns the content type, or the type of the extension of the key when it is empty
+func contentTypeOf(key, contentType string) string {
+	if contentType != "" {
+		return contentType
+	}
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
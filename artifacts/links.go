@@ -0,0 +1,121 @@
+package artifacts
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLinkTTL is how long signed links stay valid by default
+const DefaultLinkTTL = 7 * 24 * time.Hour
+
+// ErrInvalidLink is returned for links that aren't signed with the secret or that expired
+var ErrInvalidLink = errors.New("invalid or expired artifact link")
+
+// Links creates and verifies signed links to download artifacts from td. The signature is an HMAC of the key
+// and the expiry time, so links can't be altered to download other artifacts.
+type Links struct {
+	// BaseURL is the public URL of the td API, e.g. https://td.example.com
+	BaseURL string
+	// Secret is the HMAC key links are signed with
+	Secret []byte
+	// TTL is how long a link stays valid. Defaults to DefaultLinkTTL.
+	TTL time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// URL returns a signed link to download the artifact with the key
+func (l *Links) URL(key string) string {
+	ttl := l.TTL
+	if ttl == 0 {
+		ttl = DefaultLinkTTL
+	}
+	expires := strconv.FormatInt(l.now().Add(ttl).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {l.sign(key, expires)}}
+	return strings.TrimRight(l.BaseURL, "/") + "/artifacts/" + escapePath(key) + "?" + query.Encode()
+}
+
+// Verify returns ErrInvalidLink when the query of the link doesn't have a valid signature for the key or the
+// link expired
+func (l *Links) Verify(key string, query url.Values) error {
+	expires := query.Get("expires")
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || len(l.Secret) == 0 {
+		return ErrInvalidLink
+	}
+	expected, _ := hex.DecodeString(l.sign(key, expires))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidLink
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || l.now().After(time.Unix(unix, 0)) {
+		return ErrInvalidLink
+	}
+	return nil
+}
+
+func (l *Links) sign(key, expires string) string {
+	return hex.EncodeToString(hmacSHA256(l.Secret, key+"\n"+expires))
+}
+
+func (l *Links) now() time.Time {
+	if l.Now == nil {
+		return time.Now()
+	}
+	return l.Now()
+}
+
+// Handler serves the artifacts of the store under /artifacts/ to requests with a valid signed link
+func Handler(store Store, links *Links) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/artifacts/")
+		if ValidKey(key) != nil || links.Verify(key, r.URL.Query()) != nil {
+			http.Error(w, ErrInvalidLink.Error(), http.StatusForbidden)
+			return
+		}
+
+		content, obj, err := store.Open(r.Context(), key)
+		if err == ErrNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Println("Unable to open artifact", key, err)
+			http.Error(w, "unable to open artifact", http.StatusInternalServerError)
+			return
+		}
+		defer content.Close()
+
+		// artifacts are downloaded, not rendered, so an uploaded HTML file can't run scripts on the origin of td
+		w.Header().Set("content-type", obj.ContentType)
+		w.Header().Set("x-content-type-options", "nosniff")
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)})
+		if disposition == "" {
+			disposition = "attachment"
+		}
+		w.Header().Set("content-disposition", disposition)
+		if obj.Size > 0 {
+			w.Header().Set("content-length", strconv.FormatInt(obj.Size, 10))
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		if _, err := io.Copy(w, content); err != nil {
+			log.Println("Unable to send artifact", key, err)
+		}
+	})
+}
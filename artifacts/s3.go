@@ -0,0 +1,242 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the checksum of an empty payload
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Options configures an S3 compatible object store
+type S3Options struct {
+	// Endpoint of an S3 compatible service like MinIO, e.g. http://localhost:9000. Buckets are addressed by
+	// path on a custom endpoint. Defaults to AWS S3 with virtual hosted buckets.
+	Endpoint string
+	// Region of the bucket. Defaults to us-east-1.
+	Region string
+	// Bucket the artifacts are stored in
+	Bucket string
+	// Prefix is prepended to the keys of artifacts
+	Prefix string
+	// AccessKey and SecretKey are the credentials to sign requests with
+	AccessKey string
+	SecretKey string
+	// HTTPClient is used for the requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// S3Store keeps artifacts in an S3 bucket. Requests are signed with AWS Signature Version 4.
+type S3Store struct {
+	options S3Options
+	now     func() time.Time
+}
+
+// NewS3Store returns a store in the bucket
+func NewS3Store(options S3Options) (*S3Store, error) {
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("the S3 artifact store needs a bucket")
+	}
+	if options.AccessKey == "" || options.SecretKey == "" {
+		return nil, fmt.Errorf("the S3 artifact store needs credentials")
+	}
+	if options.Region == "" {
+		options.Region = "us-east-1"
+	}
+	if options.Endpoint != "" {
+		if _, err := url.Parse(options.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+		}
+		options.Endpoint = strings.TrimRight(options.Endpoint, "/")
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = http.DefaultClient
+	}
+	return &S3Store{options: options, now: time.Now}, nil
+}
+
+// Put uploads the content. It is buffered in a temporary file first, as the request is signed with the
+// checksum of the content.
+func (s *S3Store) Put(ctx context.Context, key, contentType string, r io.Reader) (*Object, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "td-artifact-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	contentType = contentTypeOf(key, contentType)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), ioutil.NopCloser(f))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	req.Header.Set("content-type", contentType)
+	resp, err := s.do(req, sum)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &Object{Key: key, ContentType: contentType, Size: size, SHA256: sum}, nil
+}
+
+// Open downloads the artifact
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := s.do(req, emptySHA256)
+	if err != nil {
+		return nil, nil, err
+	}
+	size, _ := strconv.ParseInt(resp.Header.Get("content-length"), 10, 64)
+	return resp.Body, &Object{Key: key, ContentType: resp.Header.Get("content-type"), Size: size}, nil
+}
+
+// objectURL returns the URL of the object, path style on custom endpoints and virtual hosted on AWS
+func (s *S3Store) objectURL(key string) string {
+	if s.options.Prefix != "" {
+		key = s.options.Prefix + "/" + key
+	}
+	if s.options.Endpoint != "" {
+		return s.options.Endpoint + "/" + s.options.Bucket + "/" + escapePath(key)
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.options.Bucket, s.options.Region, escapePath(key))
+}
+
+// do signs and sends the request. Responses other than 2xx are returned as error, a 404 as ErrNotFound.
+func (s *S3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	signV4(req, payloadHash, s.options.Region, s.options.AccessKey, s.options.SecretKey, s.now().UTC())
+	resp, err := s.options.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, fmt.Errorf("S3 %s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// signV4 adds the AWS Signature Version 4 authorization header for the S3 service to the request. The host,
+// range, content type and x-amz-* headers are signed.
+func signV4(req *http.Request, payloadHash, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery returns the query sorted by key with the keys and values URI encoded
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// escapePath URI encodes every segment of the path
+func escapePath(p string) string {
+	return uriEncode(p, false)
+}
+
+// uriEncode encodes everything but the unreserved characters of RFC 3986, and slashes unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
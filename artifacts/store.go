@@ -0,0 +1,72 @@
+// Package artifacts stores the files workflows produce, like logs, heap dumps and reports, and creates signed
+// links to download them from td. Stores are selected with a location: a directory for FileStore or
+// s3://bucket/prefix for an S3 compatible object store.
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned when there is no artifact with the key
+var ErrNotFound = errors.New("artifact not found")
+
+// Object describes a stored artifact
+type Object struct {
+	// Key is the path of the artifact in the store
+	Key string
+	// ContentType is the media type of the content
+	ContentType string
+	// Size of the content in bytes
+	Size int64
+	// SHA256 is the hex encoded checksum of the content. Only set by Put.
+	SHA256 string
+}
+
+// Store keeps artifacts by key. Keys are slash separated paths like workflow-id/run-id/heap.dump.
+type Store interface {
+	// Put stores the content of r under key, replacing an existing artifact
+	Put(ctx context.Context, key, contentType string, r io.Reader) (*Object, error)
+	// Open returns the content of the artifact. The caller closes it.
+	Open(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+}
+
+// Open returns the store at location, which is a directory or an s3://bucket/prefix URL. The S3 URL takes the
+// endpoint and region as query parameters, for example s3://artifacts?endpoint=http://localhost:9000, and the
+// credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func Open(location string) (Store, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return NewFileStore(location)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact store %q: %w", location, err)
+	}
+	return NewS3Store(S3Options{
+		Endpoint:  u.Query().Get("endpoint"),
+		Region:    u.Query().Get("region"),
+		Bucket:    u.Host,
+		Prefix:    strings.Trim(u.Path, "/"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	})
+}
+
+// ValidKey returns an error when the key is empty or could escape the store
+func ValidKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid artifact key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid artifact key %q", key)
+		}
+	}
+	return nil
+}
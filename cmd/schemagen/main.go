@@ -0,0 +1,515 @@
+// Command schemagen generates Go types from the Dispatch JSON Schema.
+//
+// Compared to the generic schema-generate tool it was written to replace, it knows about the parts of the schema
+// Dispatch relies on: enums become typed string constants, date-time strings become time.Time and nullable
+// properties become pointers that are marshalled as null when not set. Optional properties that would fail
+// validation when empty, like unset date-time and email values, are left out, as are unset properties that
+// have a default in the schema.
+//
+//	go run ./cmd/schemagen -p schema -i schema/dispatch-workflow.schema.json -o schema/workflow_schema_generated.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+func main() {
+	var pkg, input, output string
+
+	flag.StringVar(&pkg, "p", "schema", "name of the generated package")
+	flag.StringVar(&input, "i", "", "JSON schema to generate types from")
+	flag.StringVar(&output, "o", "", "file to write the generated code to (default: stdout)")
+	flag.Parse()
+
+	if input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	b, err := ioutil.ReadFile(input)
+	if err != nil {
+		log.Fatalln("Unable to read schema", err)
+	}
+	root := &Schema{}
+	if err := json.Unmarshal(b, root); err != nil {
+		log.Fatalln("Unable to parse schema", err)
+	}
+
+	src, err := Generate(pkg, root)
+	if err != nil {
+		log.Fatalln("Unable to generate code", err)
+	}
+
+	if output == "" {
+		_, err = os.Stdout.Write(src)
+	} else {
+		err = ioutil.WriteFile(output, src, 0644)
+	}
+	if err != nil {
+		log.Fatalln("Unable to write code", err)
+	}
+}
+
+// Schema is the subset of JSON Schema used by Dispatch
+type Schema struct {
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Type        string             `json:"type"`
+	Format      string             `json:"format"`
+	Nullable    bool               `json:"nullable"`
+	Ref         string             `json:"$ref"`
+	Enum        []string           `json:"enum"`
+	Default     json.RawMessage    `json:"default"`
+	Items       *Schema            `json:"items"`
+	Properties  map[string]*Schema `json:"properties"`
+	Required    []string           `json:"required"`
+	Definitions map[string]*Schema `json:"definitions"`
+}
+
+// generator holds the state while generating the code for one schema
+type generator struct {
+	buf         bytes.Buffer
+	definitions map[string]*Schema
+	usesErrors  bool
+}
+
+// Generate returns the formatted Go source for the root schema and its definitions
+func Generate(pkg string, root *Schema) ([]byte, error) {
+	g := &generator{definitions: root.Definitions}
+
+	types := map[string]*Schema{}
+	for name, def := range root.Definitions {
+		types[name] = def
+	}
+	if root.Title == "" {
+		return nil, fmt.Errorf("the root schema needs a title to name its type")
+	}
+	types[root.Title] = root
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body bytes.Buffer
+	for _, name := range names {
+		def := types[name]
+		var err error
+		switch {
+		case len(def.Enum) > 0:
+			err = g.enum(&body, name, def)
+		case def.Type == "object":
+			err = g.object(&body, name, def)
+		default:
+			err = fmt.Errorf("definition %s: unsupported type %q", name, def.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Fprintf(&g.buf, "// Code generated by schemagen. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	g.buf.WriteString("import (\n\t\"bytes\"\n\t\"encoding/json\"\n")
+	if g.usesErrors {
+		g.buf.WriteString("\t\"errors\"\n")
+	}
+	g.buf.WriteString("\t\"fmt\"\n\t\"time\"\n)\n\n")
+	g.buf.Write(body.Bytes())
+	g.buf.WriteString(runtime)
+
+	return format.Source(g.buf.Bytes())
+}
+
+// property is a property of an object with the Go type it maps to
+type property struct {
+	name     string
+	field    string
+	goType   string
+	schema   *Schema
+	required bool
+	pointer  bool
+	dateTime bool
+	enum     bool
+	array    bool
+}
+
+func (g *generator) enum(w *bytes.Buffer, name string, def *Schema) error {
+	if def.Type != "string" {
+		return fmt.Errorf("enum %s: only string enums are supported", name)
+	}
+
+	fmt.Fprintf(w, "// %s %s\ntype %s string\n\n", name, strings.TrimSpace(def.Description), name)
+	fmt.Fprintf(w, "// Values of %s\nconst (\n", name)
+	for _, v := range def.Enum {
+		fmt.Fprintf(w, "\t%s%s %s = %q\n", name, goName(v), name, v)
+	}
+	w.WriteString(")\n\n")
+
+	fmt.Fprintf(w, "// Valid returns true when the value is one of the values of the enumeration\n")
+	fmt.Fprintf(w, "func (e %s) Valid() bool {\n\tswitch e {\n\tcase ", name)
+	for i, v := range def.Enum {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		fmt.Fprintf(w, "%s%s", name, goName(v))
+	}
+	w.WriteString(":\n\t\treturn true\n\t}\n\treturn false\n}\n\n")
+
+	fmt.Fprintf(w, `func (e %[1]s) MarshalJSON() ([]byte, error) {
+	if !e.Valid() {
+		return nil, fmt.Errorf("invalid %[1]s %%q", string(e))
+	}
+	return json.Marshal(string(e))
+}
+
+func (e *%[1]s) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	// an empty value is accepted so payloads written before the value was set can still be read
+	if v := %[1]s(s); v == "" || v.Valid() {
+		*e = v
+		return nil
+	}
+	return fmt.Errorf("invalid %[1]s %%q", s)
+}
+
+`, name)
+	return nil
+}
+
+func (g *generator) object(w *bytes.Buffer, name string, def *Schema) error {
+	props, err := g.properties(name, def)
+	if err != nil {
+		return err
+	}
+
+	if def.Description != "" {
+		fmt.Fprintf(w, "// %s %s\n", name, strings.TrimSpace(def.Description))
+	} else {
+		fmt.Fprintf(w, "// %s\n", name)
+	}
+	fmt.Fprintf(w, "type %s struct {\n", name)
+	for _, p := range props {
+		tag := p.name
+		if !p.required {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(w, "\t%s %s `json:%q`\n", p.field, p.goType, tag)
+	}
+	w.WriteString("}\n\n")
+
+	g.marshal(w, name, props)
+	g.unmarshal(w, name, props)
+	return nil
+}
+
+// properties returns the properties of an object sorted by name
+func (g *generator) properties(name string, def *Schema) ([]*property, error) {
+	required := map[string]bool{}
+	for _, r := range def.Required {
+		required[r] = true
+	}
+
+	names := make([]string, 0, len(def.Properties))
+	for n := range def.Properties {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	props := make([]*property, 0, len(names))
+	for _, n := range names {
+		p := &property{name: n, field: goName(n), schema: def.Properties[n], required: required[n]}
+		g.usesErrors = g.usesErrors || p.required
+		if err := g.resolve(p); err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", name, n, err)
+		}
+		props = append(props, p)
+	}
+	return props, nil
+}
+
+// resolve sets the Go type of a property
+func (g *generator) resolve(p *property) error {
+	s := p.schema
+	if s.Ref != "" {
+		refName, ref, err := g.ref(s.Ref)
+		if err != nil {
+			return err
+		}
+		if len(ref.Enum) > 0 {
+			p.goType, p.enum = refName, true
+		} else {
+			p.goType, p.pointer = "*"+refName, true
+		}
+		return nil
+	}
+
+	switch s.Type {
+	case "string":
+		p.goType = "string"
+		if s.Format == "date-time" {
+			p.goType, p.dateTime = "time.Time", true
+		}
+	case "integer":
+		p.goType = "int"
+	case "number":
+		p.goType = "float64"
+	case "boolean":
+		p.goType = "bool"
+	case "object":
+		p.goType = "map[string]interface{}"
+		return nil
+	case "array":
+		p.array = true
+		if s.Items == nil {
+			p.goType = "[]interface{}"
+			return nil
+		}
+		if s.Items.Ref != "" {
+			refName, ref, err := g.ref(s.Items.Ref)
+			if err != nil {
+				return err
+			}
+			if len(ref.Enum) > 0 {
+				p.goType = "[]" + refName
+			} else {
+				p.goType = "[]*" + refName
+			}
+			return nil
+		}
+		item := &property{schema: s.Items}
+		if err := g.resolve(item); err != nil {
+			return err
+		}
+		p.goType = "[]" + item.goType
+		return nil
+	default:
+		return fmt.Errorf("unsupported type %q", s.Type)
+	}
+
+	if s.Nullable {
+		p.goType, p.pointer = "*"+p.goType, true
+	}
+	return nil
+}
+
+// ref resolves a reference to one of the definitions of the schema
+func (g *generator) ref(ref string) (string, *Schema, error) {
+	const prefix = "#/definitions/"
+	if !strings.HasPrefix(ref, prefix) {
+		return "", nil, fmt.Errorf("unsupported reference %q", ref)
+	}
+	name := strings.TrimPrefix(ref, prefix)
+	def, ok := g.definitions[name]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown reference %q", ref)
+	}
+	return name, def, nil
+}
+
+func (g *generator) marshal(w *bytes.Buffer, name string, props []*property) {
+	fmt.Fprintf(w, "func (strct %s) MarshalJSON() ([]byte, error) {\n\tobj := &jsonObject{}\n", name)
+	for _, p := range props {
+		v := "strct." + p.field
+		switch {
+		case p.dateTime && p.pointer:
+			fmt.Fprintf(w, "\tif %s == nil {\n\t\tobj.null(%q)\n\t} else {\n\t\tobj.dateTime(%q, *%s)\n\t}\n", v, p.name, p.name, v)
+		case p.dateTime && !p.required:
+			// the zero time is not a valid date-time, leaving it out is
+			fmt.Fprintf(w, "\tif !%s.IsZero() {\n\t\tobj.dateTime(%q, %s)\n\t}\n", v, p.name, v)
+		case p.dateTime:
+			fmt.Fprintf(w, "\tobj.dateTime(%q, %s)\n", p.name, v)
+		case p.pointer && p.schema.Ref != "" && !p.required:
+			// references can't be null, so a missing object is left out
+			fmt.Fprintf(w, "\tif %s != nil {\n\t\tobj.field(%q, %s)\n\t}\n", v, p.name, v)
+		case p.pointer:
+			fmt.Fprintf(w, "\tif %s == nil {\n\t\tobj.null(%q)\n\t} else {\n\t\tobj.field(%q, %s)\n\t}\n", v, p.name, p.name, v)
+		case p.enum && !p.required:
+			fmt.Fprintf(w, "\tif %s != \"\" {\n\t\tobj.field(%q, %s)\n\t}\n", v, p.name, v)
+		case p.schema.Format == "email" && !p.required:
+			// an empty string is not a valid email address
+			fmt.Fprintf(w, "\tif %s != \"\" {\n\t\tobj.field(%q, %s)\n\t}\n", v, p.name, v)
+		case p.array && string(p.schema.Default) == "[]":
+			fmt.Fprintf(w, "\tif %s == nil {\n\t\tobj.raw(%q, \"[]\")\n\t} else {\n\t\tobj.field(%q, %s)\n\t}\n", v, p.name, p.name, v)
+		case len(p.schema.Default) > 0 && !p.required && p.goType == "bool":
+			fmt.Fprintf(w, "\tif %s {\n\t\tobj.field(%q, %s)\n\t}\n", v, p.name, v)
+		case len(p.schema.Default) > 0 && !p.required && zero(p.goType) != "":
+			// leave out unset values so Dispatch applies the default of the schema
+			fmt.Fprintf(w, "\tif %s != %s {\n\t\tobj.field(%q, %s)\n\t}\n", v, zero(p.goType), p.name, v)
+		default:
+			fmt.Fprintf(w, "\tobj.field(%q, %s)\n", p.name, v)
+		}
+	}
+	w.WriteString("\treturn obj.bytes()\n}\n\n")
+}
+
+func (g *generator) unmarshal(w *bytes.Buffer, name string, props []*property) {
+	fmt.Fprintf(w, "func (strct *%s) UnmarshalJSON(b []byte) error {\n", name)
+	for _, p := range props {
+		if p.required {
+			fmt.Fprintf(w, "\t%sReceived := false\n", lowerFirst(p.field))
+		}
+	}
+	w.WriteString("\tvar jsonMap map[string]json.RawMessage\n\tif err := json.Unmarshal(b, &jsonMap); err != nil {\n\t\treturn err\n\t}\n")
+	w.WriteString("\t// parse all the defined properties\n\tfor k, v := range jsonMap {\n\t\tswitch k {\n")
+	for _, p := range props {
+		fmt.Fprintf(w, "\t\tcase %q:\n", p.name)
+		switch {
+		case p.dateTime && p.pointer:
+			fmt.Fprintf(w, "\t\t\tt, err := parseDateTime(v)\n\t\t\tif err != nil {\n\t\t\t\treturn err\n\t\t\t}\n")
+			fmt.Fprintf(w, "\t\t\tif t.IsZero() {\n\t\t\t\tstrct.%s = nil\n\t\t\t} else {\n\t\t\t\tstrct.%s = &t\n\t\t\t}\n", p.field, p.field)
+		case p.dateTime:
+			fmt.Fprintf(w, "\t\t\tt, err := parseDateTime(v)\n\t\t\tif err != nil {\n\t\t\t\treturn err\n\t\t\t}\n\t\t\tstrct.%s = t\n", p.field)
+		default:
+			fmt.Fprintf(w, "\t\t\tif err := json.Unmarshal([]byte(v), &strct.%s); err != nil {\n\t\t\t\treturn err\n\t\t\t}\n", p.field)
+		}
+		if p.required {
+			fmt.Fprintf(w, "\t\t\t%sReceived = true\n", lowerFirst(p.field))
+		}
+	}
+	w.WriteString("\t\t}\n\t}\n")
+	for _, p := range props {
+		if p.required {
+			fmt.Fprintf(w, "\t// check if %[1]s (a required property) was received\n\tif !%[2]sReceived {\n\t\treturn errors.New(\"\\\"%[1]s\\\" is required but was not present\")\n\t}\n", p.name, lowerFirst(p.field))
+		}
+	}
+	w.WriteString("\treturn nil\n}\n\n")
+}
+
+// zero returns the zero value of string and number Go types or an empty string for other types
+func zero(goType string) string {
+	switch goType {
+	case "string":
+		return `""`
+	case "int", "float64":
+		return "0"
+	}
+	return ""
+}
+
+// goName converts a property name or enum value like resource_id to a Go name like ResourceId
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		switch {
+		case r == '_' || r == '-' || r == ' ' || r == '.':
+			upper = true
+		case upper:
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// runtime is added to every generated file so the generated code doesn't depend on anything else in its package
+const runtime = `// jsonObject writes the fields of a JSON object in the order they are added
+type jsonObject struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (o *jsonObject) key(name string) {
+	if o.buf.Len() == 0 {
+		o.buf.WriteString("{")
+	} else {
+		o.buf.WriteString(",")
+	}
+	k, _ := json.Marshal(name)
+	o.buf.Write(k)
+	o.buf.WriteString(":")
+}
+
+func (o *jsonObject) field(name string, v interface{}) {
+	if o.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		o.err = fmt.Errorf("%s: %w", name, err)
+		return
+	}
+	o.key(name)
+	o.buf.Write(b)
+}
+
+func (o *jsonObject) raw(name, v string) {
+	o.key(name)
+	o.buf.WriteString(v)
+}
+
+func (o *jsonObject) null(name string) {
+	o.raw(name, "null")
+}
+
+func (o *jsonObject) dateTime(name string, t time.Time) {
+	o.field(name, t.UTC().Format(DateTimeFormat))
+}
+
+func (o *jsonObject) bytes() ([]byte, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	if o.buf.Len() == 0 {
+		return []byte("{}"), nil
+	}
+	o.buf.WriteString("}")
+	return o.buf.Bytes(), nil
+}
+
+// DateTimeFormat is the layout date-time values are written in. It defaults to RFC 3339 as required by the
+// schema. Dispatch versions that can't handle a time zone need LegacyDateTimeFormat. Values are written in UTC.
+var DateTimeFormat = time.RFC3339Nano
+
+// LegacyDateTimeFormat is the layout without time zone that older Dispatch versions expect
+const LegacyDateTimeFormat = "2006-01-02 15:04:05"
+
+// dateTimeLayouts are the layouts date-time values are parsed with. Besides RFC 3339, Dispatch sends
+// timestamps without a time zone, which are taken as UTC.
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// parseDateTime parses a JSON string with a date-time. Empty strings and null result in the zero time.
+func parseDateTime(b []byte) (time.Time, error) {
+	var s *string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return time.Time{}, err
+	}
+	if s == nil || *s == "" {
+		return time.Time{}, nil
+	}
+	var err error
+	for _, layout := range dateTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, *s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+`
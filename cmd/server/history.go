@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyCommand runs td history export, which saves the event history of a workflow run as JSON for the
+// replay tests
+func historyCommand(args []string) error {
+	if len(args) == 0 || args[0] != "export" {
+		return fmt.Errorf("usage: td history export -workflow-id <id> [-run-id <id>] [-o <file> | -dir <dir>]")
+	}
+
+	var temporalAddr, namespace, workflowID, runID, out, dir string
+	fs := flag.NewFlagSet("history export", flag.ExitOnError)
+	fs.StringVar(&temporalAddr, "temporal", "localhost:7233", "host and port that temporal is listening on")
+	fs.StringVar(&namespace, "namespace", "default", "the temporal namespace of the workflow")
+	fs.StringVar(&workflowID, "workflow-id", "", "the temporal workflow id, e.g. random_dog-25")
+	fs.StringVar(&runID, "run-id", "", "the run to export (default: the latest run)")
+	fs.StringVar(&out, "o", "", "file to write the history to (default: stdout)")
+	fs.StringVar(&dir, "dir", "", "directory to write the history to as <workflow name>/<workflow id>_<run id>.json, e.g. workflows/testdata/histories")
+	fs.Parse(args[1:])
+
+	if workflowID == "" {
+		return fmt.Errorf("-workflow-id is required")
+	}
+
+	client, err := workflows.NewWorkflowStarter(workflows.Options{HostPort: temporalAddr, Namespace: namespace})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	history, err := client.History(ctx, workflowID, runID)
+	if err != nil {
+		return err
+	}
+
+	if dir != "" {
+		if history.Workflow == "" {
+			return fmt.Errorf("workflow %s is not registered, use -o to export it", workflowID)
+		}
+		out = filepath.Join(dir, history.Workflow, history.WorkflowID+"_"+history.RunID+".json")
+		if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := history.WriteJSON(w); err != nil {
+		return err
+	}
+	if out != "" {
+		fmt.Fprintln(os.Stderr, "History written to", out)
+	}
+	return nil
+}
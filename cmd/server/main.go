@@ -3,29 +3,122 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"github.com/jtorvald/temporal-dispatch-poc/api"
+	"github.com/jtorvald/temporal-dispatch-poc/artifacts"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
 	"github.com/jtorvald/temporal-dispatch-poc/workflows"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
-// turned off go:generate schema-generate -p schema -i ../../schema/dispatch-workflow.schema.json -o ../../schema/workflow_schema_generated.go
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		if err := historyCommand(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 
-	var apiAddr, temporalAddr, queue string
+	var apiAddr, temporalAddr, queue, namespace, webURL, callbackURL, callbackToken, dispatchURL, dispatchToken string
+	var timeFormat, dispatchVersion, baseURLs, configPath, rateLimits string
+	var artifactLocation, artifactSecret, publicURL string
+	var artifactLinkTTL time.Duration
+	var mirror bool
+	var mirrorMaxSize int64
 
 	flag.StringVar(&apiAddr, "api", "localhost:8888", "interface and port to have the API listen on (default: localhost:8888)")
 	flag.StringVar(&temporalAddr, "temporal", "localhost:7233", "host and port that temporal is listening on (default: localhost:7233)")
 	flag.StringVar(&queue, "queue", "dispatch", "the temporal queue to work with (default: dispatch")
+	flag.StringVar(&namespace, "namespace", "default", "the temporal namespace to run workflows in (default: default)")
+	flag.StringVar(&webURL, "temporal-web", "http://localhost:8088", "base URL of the Temporal Web UI used for weblinks in Dispatch (default: http://localhost:8088)")
+	flag.StringVar(&callbackURL, "callback-url", "", "URL that receives workflow status updates as they happen (default: disabled)")
+	flag.StringVar(&callbackToken, "callback-token", "", "bearer token sent with every callback")
+	flag.StringVar(&dispatchURL, "dispatch", "", "base URL of the Dispatch API with organization, e.g. http://localhost:8000/api/v1/default")
+	flag.StringVar(&dispatchToken, "dispatch-token", "", "bearer token for the Dispatch API")
+	flag.StringVar(&timeFormat, "time-format", "rfc3339", "format of timestamps sent to Dispatch: rfc3339 or legacy (2006-01-02 15:04:05, for older Dispatch versions)")
+	flag.StringVar(&dispatchVersion, "dispatch-version", versions.Latest, fmt.Sprintf("shape of the updates sent to Dispatch when a request has no %s header: %s", versions.Header, strings.Join(versions.Supported(), ", ")))
+	flag.StringVar(&baseURLs, "base-urls", "", "comma separated service=url pairs that point activities to other endpoints, e.g. dog=http://localhost:9000/api,unsplash=http://localhost:9001")
+	flag.StringVar(&configPath, "config", "", "JSON file with timeouts and retry policies of workflows and activities")
+	flag.StringVar(&artifactLocation, "artifacts", "", "directory or s3://bucket/prefix?endpoint=...&region=... to store the files workflows produce (default: disabled)")
+	flag.StringVar(&artifactSecret, "artifact-secret", "", "secret to sign artifact download links with (default: $TD_ARTIFACT_SECRET)")
+	flag.StringVar(&publicURL, "public-url", "", "URL the API is reachable on for artifact download links (default: http://<api>)")
+	flag.DurationVar(&artifactLinkTTL, "artifact-link-ttl", artifacts.DefaultLinkTTL, "how long artifact download links stay valid")
+	flag.BoolVar(&mirror, "mirror", false, "store a copy of the images the example workflows link to in the artifact store (requires -artifacts)")
+	flag.Int64Var(&mirrorMaxSize, "mirror-max-size", workflows.DefaultMirrorMaxBytes, "largest image in bytes that is mirrored")
+	flag.StringVar(&rateLimits, "rate-limits", "", "comma separated limits of the workflow endpoints per client, e.g. ip=5/s,key=300/m:50,workflow=1/s (default: disabled)")
 	flag.Parse()
 
+	if timeFormat != "rfc3339" && timeFormat != "legacy" {
+		log.Fatalf("unsupported -time-format %q, use rfc3339 or legacy", timeFormat)
+	}
+
+	urls, err := workflows.ParseBaseURLs(baseURLs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	env := workflows.Environment{BaseURLs: urls}
+	config := &workflows.Config{}
+	if configPath != "" {
+		if config, err = workflows.LoadConfig(configPath); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if config.HTTPTimeout != 0 {
+		env.HTTPClient = &http.Client{Timeout: time.Duration(config.HTTPTimeout)}
+	}
+
+	apiOptions := api.Options{Addr: apiAddr, DispatchVersion: dispatchVersion, LegacyTimes: timeFormat == "legacy"}
+	if apiOptions.RateLimits, err = api.ParseRateLimits(rateLimits); err != nil {
+		log.Fatalln(err)
+	}
+	if artifactLocation != "" {
+		if apiOptions.Artifacts, err = artifacts.Open(artifactLocation); err != nil {
+			log.Fatalln(err)
+		}
+		if artifactSecret == "" {
+			artifactSecret = os.Getenv("TD_ARTIFACT_SECRET")
+		}
+		if artifactSecret == "" {
+			log.Fatalln("-artifact-secret or TD_ARTIFACT_SECRET is required to sign artifact links")
+		}
+		if publicURL == "" {
+			publicURL = "http://" + apiAddr
+		}
+		apiOptions.ArtifactLinks = &artifacts.Links{BaseURL: publicURL, Secret: []byte(artifactSecret), TTL: artifactLinkTTL}
+		env.Artifacts = &workflows.ArtifactStorage{Store: apiOptions.Artifacts, Links: apiOptions.ArtifactLinks}
+	}
+	if mirror {
+		if env.Artifacts == nil {
+			log.Fatalln("-mirror requires an artifact store, set -artifacts")
+		}
+		env.Mirror = &workflows.Mirror{Storage: env.Artifacts, MaxBytes: mirrorMaxSize}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	go func() {
-		if err := run(ctx, apiAddr, temporalAddr, queue); err != nil {
+		if err := run(ctx, apiOptions, workflows.Options{
+			HostPort:          temporalAddr,
+			Namespace:         namespace,
+			Queue:             queue,
+			WebURL:            webURL,
+			CallbackURL:       callbackURL,
+			CallbackToken:     callbackToken,
+			DispatchURL:       dispatchURL,
+			DispatchToken:     dispatchToken,
+			DispatchVersion:   dispatchVersion,
+			LegacyTimes:       timeFormat == "legacy",
+			Environment:       env,
+			Policies:          config.Workflows,
+			MaxConcurrentRuns: config.MaxConcurrentRuns,
+		}); err != nil {
 			panic(err)
 		}
 	}()
@@ -59,18 +152,20 @@ func main() {
 	time.Sleep(4 * time.Second)
 }
 
-func run(ctx context.Context, apiAddr, temporalAddr, queue string) error {
+func run(ctx context.Context, apiOptions api.Options, options workflows.Options) error {
 
-	client, err := workflows.NewWorkflowStarter(temporalAddr, queue)
+	client, err := workflows.NewWorkflowStarter(options)
 	if err != nil {
 		return err
 	}
 	// start api and listen on port 8888 in the background
-	api.ListenAndServe(ctx, apiAddr, client)
+	if err := api.ListenAndServe(ctx, apiOptions, client); err != nil {
+		return err
+	}
 
 	// start the background worker
 	go client.StartWorkflowWorker(ctx)
-	log.Println("Listening to Temporal server: ", temporalAddr, " on queue: ", queue)
+	log.Println("Listening to Temporal server: ", options.HostPort, " on queue: ", options.Queue)
 
 	return nil
 }
@@ -0,0 +1,172 @@
+// Package dispatch is a small client for the Dispatch API. It lets workflows read the incident they run for
+// and write back to it. Messages to the incident conversation aren't supported, Dispatch only posts them through
+// its plugins.
+package dispatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures the Dispatch client
+type Options struct {
+	// BaseURL of the Dispatch API including the organization, for example https://dispatch.example.com/api/v1/default
+	BaseURL string
+	// Token is sent as bearer token with every request when set
+	Token string
+	// HTTPClient is used for the requests. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// Client reads and updates incidents through the Dispatch API
+type Client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// Error is returned when the Dispatch API responds with an error status
+type Error struct {
+	StatusCode int
+	Detail     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dispatch: status %d: %s", e.StatusCode, e.Detail)
+}
+
+// IsNotFound returns true when err is a not found response of the Dispatch API
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// NewClient returns a client for the Dispatch API
+func NewClient(options Options) (*Client, error) {
+	if options.BaseURL == "" {
+		return nil, errors.New("dispatch: base URL is required")
+	}
+	if _, err := url.Parse(options.BaseURL); err != nil {
+		return nil, fmt.Errorf("dispatch: invalid base URL: %w", err)
+	}
+
+	c := &Client{
+		baseURL: strings.TrimRight(options.BaseURL, "/"),
+		token:   options.Token,
+		http:    options.HTTPClient,
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 10 * time.Second}
+	}
+	return c, nil
+}
+
+// GetIncident returns the incident with the given id
+func (c *Client) GetIncident(ctx context.Context, incidentID int) (*Incident, error) {
+	incident := &Incident{}
+	if err := c.do(ctx, http.MethodGet, incidentPath(incidentID), nil, incident); err != nil {
+		return nil, err
+	}
+	return incident, nil
+}
+
+// ListParticipants returns the participants of an incident
+func (c *Client) ListParticipants(ctx context.Context, incidentID int) ([]*Participant, error) {
+	incident, err := c.GetIncident(ctx, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	return incident.Participants, nil
+}
+
+// CreateDocument adds a document to Dispatch. Set the project of the document to have it show up in the
+// right project.
+func (c *Client) CreateDocument(ctx context.Context, document *schema.DocumentCreate) (*Document, error) {
+	created := &Document{}
+	if err := c.do(ctx, http.MethodPost, "/documents", document, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// AddTimelineEvent adds a custom event to the timeline of an incident
+func (c *Client) AddTimelineEvent(ctx context.Context, incidentID int, event *Event) error {
+	return c.do(ctx, http.MethodPost, incidentPath(incidentID)+"/event", event, nil)
+}
+
+// do sends a request with body encoded as JSON and decodes the response into result when it is not nil
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("accept", "application/json")
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// responseError reads the detail Dispatch sends along with an error status
+func responseError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	var detail struct {
+		Detail interface{} `json:"detail"`
+	}
+	if err := json.Unmarshal(b, &detail); err == nil && detail.Detail != nil {
+		if s, ok := detail.Detail.(string); ok {
+			apiErr.Detail = s
+		} else {
+			d, _ := json.Marshal(detail.Detail)
+			apiErr.Detail = string(d)
+		}
+	} else {
+		apiErr.Detail = strings.TrimSpace(string(b))
+	}
+	if apiErr.Detail == "" {
+		apiErr.Detail = http.StatusText(resp.StatusCode)
+	}
+	return apiErr
+}
+
+func incidentPath(incidentID int) string {
+	return "/incidents/" + strconv.Itoa(incidentID)
+}
@@ -0,0 +1,86 @@
+package dispatch_test
+
+import (
+	"context"
+	"github.com/jtorvald/temporal-dispatch-poc/dispatch"
+	"github.com/jtorvald/temporal-dispatch-poc/dispatch/dispatchtest"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"testing"
+)
+
+func newClient(t *testing.T, token string) (*dispatch.Client, *dispatchtest.Server) {
+	t.Helper()
+	srv := dispatchtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.Token = "secret"
+	srv.AddIncident(&dispatch.Incident{
+		ID:    32,
+		Name:  "dispatch-default-default-32",
+		Title: "Checkout is down",
+		Participants: []*dispatch.Participant{{
+			ID:               1,
+			Individual:       &dispatch.Individual{Name: "Jane", Email: "jane@example.com"},
+			ParticipantRoles: []*dispatch.ParticipantRole{{Role: "Incident Commander"}},
+		}},
+	})
+
+	c, err := dispatch.NewClient(dispatch.Options{BaseURL: srv.URL, Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv
+}
+
+func TestGetIncident(t *testing.T) {
+	c, _ := newClient(t, "secret")
+
+	incident, err := c.GetIncident(context.Background(), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident.Title != "Checkout is down" {
+		t.Errorf("title = %q", incident.Title)
+	}
+
+	participants, err := c.ListParticipants(context.Background(), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(participants) != 1 || !participants[0].HasRole("Incident Commander") {
+		t.Errorf("participants = %+v", participants)
+	}
+
+	if _, err := c.GetIncident(context.Background(), 33); !dispatch.IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	c, _ := newClient(t, "wrong")
+
+	_, err := c.GetIncident(context.Background(), 32)
+	apiErr, ok := err.(*dispatch.Error)
+	if !ok || apiErr.StatusCode != 401 || apiErr.Detail != "Could not validate credentials" {
+		t.Errorf("expected unauthorized error, got %v", err)
+	}
+}
+
+func TestWriteToIncident(t *testing.T) {
+	c, srv := newClient(t, "secret")
+	ctx := context.Background()
+
+	doc, err := c.CreateDocument(ctx, &schema.DocumentCreate{Name: "heap.dump", Weblink: schema.String("https://example.com/heap.dump")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.ID != 1 || doc.Name != "heap.dump" || len(srv.Documents()) != 1 {
+		t.Errorf("document = %+v", doc)
+	}
+
+	if err := c.AddTimelineEvent(ctx, 32, &dispatch.Event{Source: "td", Description: "Heap dump taken"}); err != nil {
+		t.Fatal(err)
+	}
+	if events := srv.Events(32); len(events) != 1 || events[0].Description != "Heap dump taken" {
+		t.Errorf("events = %+v", events)
+	}
+}
@@ -0,0 +1,140 @@
+// Package dispatchtest provides a fake Dispatch API server for tests
+package dispatchtest
+
+import (
+	"encoding/json"
+	"github.com/jtorvald/temporal-dispatch-poc/dispatch"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a fake Dispatch API that keeps incidents, documents and timeline events in memory. Use URL as base
+// URL of the dispatch client.
+type Server struct {
+	*httptest.Server
+
+	// Token is the bearer token clients have to send. Requests are not authenticated when it is empty.
+	Token string
+
+	mu        sync.Mutex
+	incidents map[int]*dispatch.Incident
+	documents []*schema.DocumentCreate
+	events    map[int][]*dispatch.Event
+}
+
+// NewServer starts a fake Dispatch API. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		incidents: map[int]*dispatch.Incident{},
+		events:    map[int][]*dispatch.Event{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddIncident makes an incident available through the API
+func (s *Server) AddIncident(incident *dispatch.Incident) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.incidents[incident.ID] = incident
+}
+
+// Documents returns the documents that were created
+func (s *Server) Documents() []*schema.DocumentCreate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*schema.DocumentCreate(nil), s.documents...)
+}
+
+// Events returns the timeline events that were added to an incident
+func (s *Server) Events(incidentID int) []*dispatch.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*dispatch.Event(nil), s.events[incidentID]...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Token != "" && r.Header.Get("authorization") != "Bearer "+s.Token {
+		writeDetail(w, http.StatusUnauthorized, "Could not validate credentials")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 1 && parts[0] == "documents":
+		s.createDocument(w, r)
+	case len(parts) >= 2 && parts[0] == "incidents":
+		incidentID, err := strconv.Atoi(parts[1])
+		if err != nil {
+			writeDetail(w, http.StatusUnprocessableEntity, "incident id is not a number")
+			return
+		}
+		s.mu.Lock()
+		incident, ok := s.incidents[incidentID]
+		s.mu.Unlock()
+		if !ok {
+			writeDetail(w, http.StatusNotFound, "The requested incident does not exist.")
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && len(parts) == 2:
+			writeJSON(w, http.StatusOK, incident)
+		case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "event":
+			s.addEvent(w, r, incidentID)
+		default:
+			writeDetail(w, http.StatusNotFound, "Not Found")
+		}
+	default:
+		writeDetail(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *Server) createDocument(w http.ResponseWriter, r *http.Request) {
+	document := &schema.DocumentCreate{}
+	if err := json.NewDecoder(r.Body).Decode(document); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.documents = append(s.documents, document)
+	id := len(s.documents)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, &dispatch.Document{
+		ID:           id,
+		Name:         document.Name,
+		Description:  schema.StringValue(document.Description),
+		ResourceID:   schema.StringValue(document.ResourceId),
+		ResourceType: schema.StringValue(document.ResourceType),
+		Weblink:      schema.StringValue(document.Weblink),
+	})
+}
+
+func (s *Server) addEvent(w http.ResponseWriter, r *http.Request, incidentID int) {
+	event := &dispatch.Event{}
+	if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+		writeDetail(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.events[incidentID] = append(s.events[incidentID], event)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeDetail(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]string{"detail": detail})
+}
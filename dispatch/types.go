@@ -0,0 +1,98 @@
+package dispatch
+
+import (
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"time"
+)
+
+// Incident is an incident as returned by the Dispatch API. Only the fields workflows are interested in
+// are decoded.
+type Incident struct {
+	ID               int                 `json:"id"`
+	Name             string              `json:"name"`
+	Title            string              `json:"title"`
+	Description      string              `json:"description"`
+	Resolution       string              `json:"resolution,omitempty"`
+	Status           string              `json:"status"`
+	Visibility       string              `json:"visibility,omitempty"`
+	ReportedAt       *time.Time          `json:"reported_at,omitempty"`
+	StableAt         *time.Time          `json:"stable_at,omitempty"`
+	ClosedAt         *time.Time          `json:"closed_at,omitempty"`
+	IncidentType     *IncidentType       `json:"incident_type,omitempty"`
+	IncidentPriority *IncidentPriority   `json:"incident_priority,omitempty"`
+	Commander        *Participant        `json:"commander,omitempty"`
+	Participants     []*Participant      `json:"participants,omitempty"`
+	Conversation     *Conversation       `json:"conversation,omitempty"`
+	Documents        []*Document         `json:"documents,omitempty"`
+	Project          *schema.ProjectRead `json:"project,omitempty"`
+}
+
+// IncidentType is the type of an incident
+type IncidentType struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// IncidentPriority is the priority of an incident
+type IncidentPriority struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// Participant is a person that takes part in an incident
+type Participant struct {
+	ID               int                `json:"id"`
+	Individual       *Individual        `json:"individual"`
+	Team             string             `json:"team,omitempty"`
+	Department       string             `json:"department,omitempty"`
+	Location         string             `json:"location,omitempty"`
+	ParticipantRoles []*ParticipantRole `json:"participant_roles,omitempty"`
+}
+
+// Individual is the contact behind a participant
+type Individual struct {
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Weblink string `json:"weblink,omitempty"`
+}
+
+// ParticipantRole is a role a participant has in an incident, like Incident Commander or Reporter
+type ParticipantRole struct {
+	Role        string     `json:"role"`
+	AssumedAt   *time.Time `json:"assumed_at,omitempty"`
+	RenouncedAt *time.Time `json:"renounced_at,omitempty"`
+}
+
+// HasRole returns true when the participant currently holds the role
+func (p *Participant) HasRole(role string) bool {
+	for _, r := range p.ParticipantRoles {
+		if r.Role == role && r.RenouncedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Conversation is the chat channel of an incident
+type Conversation struct {
+	ChannelID string `json:"channel_id"`
+	ThreadID  string `json:"thread_id,omitempty"`
+	Weblink   string `json:"weblink,omitempty"`
+}
+
+// Document is a document as returned by the Dispatch API
+type Document struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Description  string `json:"description,omitempty"`
+	ResourceID   string `json:"resource_id,omitempty"`
+	ResourceType string `json:"resource_type,omitempty"`
+	Weblink      string `json:"weblink,omitempty"`
+}
+
+// Event is an entry in the timeline of an incident
+type Event struct {
+	Source      string                 `json:"source"`
+	Description string                 `json:"description"`
+	Details     map[string]interface{} `json:"details,omitempty"`
+}
@@ -0,0 +1,25 @@
+// Package schema contains the types of the Dispatch generic workflow plugin. The types are generated from the
+// JSON Schema in dispatch-workflow.schema.json, run go generate after updating it to a newer Dispatch version.
+package schema
+
+import "time"
+
+//go:generate go run ../cmd/schemagen -p schema -i dispatch-workflow.schema.json -o workflow_schema_generated.go
+
+// String returns a pointer to s for the nullable string fields
+func String(s string) *string {
+	return &s
+}
+
+// StringValue returns the string s points to or an empty string when it is nil
+func StringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Time returns a pointer to t for the nullable date-time fields
+func Time(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,24 @@
+package schema
+
+// statusTransitions lists the statuses a workflow instance can move to from each status
+var statusTransitions = map[WorkflowInstanceStatus][]WorkflowInstanceStatus{
+	WorkflowInstanceStatusSubmitted: {WorkflowInstanceStatusCreated, WorkflowInstanceStatusRunning, WorkflowInstanceStatusFailed},
+	WorkflowInstanceStatusCreated:   {WorkflowInstanceStatusRunning, WorkflowInstanceStatusFailed},
+	WorkflowInstanceStatusRunning:   {WorkflowInstanceStatusCompleted, WorkflowInstanceStatusFailed},
+}
+
+// CanTransition returns true when a workflow instance with status e is allowed to move to status next. Completed
+// and Failed are final, nothing moves back to an earlier status.
+func (e WorkflowInstanceStatus) CanTransition(next WorkflowInstanceStatus) bool {
+	for _, s := range statusTransitions[e] {
+		if s == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Final returns true when the workflow instance has finished
+func (e WorkflowInstanceStatus) Final() bool {
+	return e == WorkflowInstanceStatusCompleted || e == WorkflowInstanceStatusFailed
+}
@@ -0,0 +1,24 @@
+package schema
+
+import "testing"
+
+func TestStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from, to WorkflowInstanceStatus
+		allowed  bool
+	}{
+		{WorkflowInstanceStatusSubmitted, WorkflowInstanceStatusCreated, true},
+		{WorkflowInstanceStatusCreated, WorkflowInstanceStatusRunning, true},
+		{WorkflowInstanceStatusCreated, WorkflowInstanceStatusCompleted, false},
+		{WorkflowInstanceStatusRunning, WorkflowInstanceStatusCompleted, true},
+		{WorkflowInstanceStatusRunning, WorkflowInstanceStatusFailed, true},
+		{WorkflowInstanceStatusRunning, WorkflowInstanceStatusCreated, false},
+		{WorkflowInstanceStatusCompleted, WorkflowInstanceStatusRunning, false},
+		{WorkflowInstanceStatusFailed, WorkflowInstanceStatusCompleted, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransition(tt.to); got != tt.allowed {
+			t.Errorf("%s -> %s: allowed = %v, want %v", tt.from, tt.to, got, tt.allowed)
+		}
+	}
+}
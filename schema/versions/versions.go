@@ -0,0 +1,215 @@
+// Package versions translates the internal state of a workflow run into the WorkflowInstanceUpdate of the
+// Dispatch version that receives it. Workflows only work with the types of the schema package, adapters take
+// care of the shape each Dispatch version expects.
+package versions
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"sort"
+	"time"
+)
+
+const (
+	// Latest follows the schema in schema/dispatch-workflow.schema.json
+	Latest = "latest"
+	// Legacy is the JSON the API of td returned before it followed the schema
+	Legacy = "legacy"
+	// Header selects the version of a single API request
+	Header = "X-Dispatch-Version"
+)
+
+// Adapter encodes workflow state for one Dispatch version
+type Adapter interface {
+	// Version is the name the adapter is selected by
+	Version() string
+	// Encode returns the JSON of the update in the shape of the version
+	Encode(update *schema.WorkflowInstanceUpdate) ([]byte, error)
+}
+
+var adapters = map[string]Adapter{}
+
+func init() {
+	Register(latestAdapter{})
+	Register(legacyAdapter{})
+}
+
+// Register makes an adapter available under its version. It panics when the version is already registered.
+func Register(a Adapter) {
+	if _, exists := adapters[a.Version()]; exists {
+		panic(fmt.Sprintf("schema version %q is already registered", a.Version()))
+	}
+	adapters[a.Version()] = a
+}
+
+// Lookup returns the adapter of version. An empty version selects Latest.
+func Lookup(version string) (Adapter, error) {
+	if version == "" {
+		version = Latest
+	}
+	a, ok := adapters[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported Dispatch version %q, supported are %v", version, Supported())
+	}
+	return a, nil
+}
+
+// Supported returns the sorted names of the registered versions
+func Supported() []string {
+	names := make([]string, 0, len(adapters))
+	for name := range adapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// latestAdapter passes the update through, its types are generated from the current schema
+type latestAdapter struct{}
+
+func (latestAdapter) Version() string {
+	return Latest
+}
+
+func (latestAdapter) Encode(update *schema.WorkflowInstanceUpdate) ([]byte, error) {
+	return json.Marshal(update)
+}
+
+// legacyAdapter encodes the update like the API of td did before it followed the schema (commit 9b6b6ab): every
+// property is sent, strings and timestamps are empty instead of null, timestamps have no time zone and the
+// evergreen properties of an artifact that isn't evergreen are left out. Dispatch rejected their empty values.
+type legacyAdapter struct{}
+
+func (legacyAdapter) Version() string {
+	return Legacy
+}
+
+func (legacyAdapter) Encode(update *schema.WorkflowInstanceUpdate) ([]byte, error) {
+	if update == nil {
+		return json.Marshal(nil)
+	}
+	artifacts := make([]interface{}, len(update.Artifacts))
+	for i, a := range update.Artifacts {
+		artifacts[i] = legacyDocument(a)
+	}
+	return json.Marshal(map[string]interface{}{
+		"artifacts":     artifacts,
+		"created_at":    legacyTime(update.CreatedAt),
+		"parameters":    update.Parameters,
+		"resource_id":   schema.StringValue(update.ResourceId),
+		"resource_type": schema.StringValue(update.ResourceType),
+		"run_reason":    schema.StringValue(update.RunReason),
+		"status":        string(update.Status),
+		"updated_at":    legacyTime(update.UpdatedAt),
+		"weblink":       schema.StringValue(update.Weblink),
+	})
+}
+
+func legacyDocument(a *schema.DocumentCreate) map[string]interface{} {
+	doc := map[string]interface{}{
+		"created_at":    legacyTimePtr(a.CreatedAt),
+		"description":   schema.StringValue(a.Description),
+		"evergreen":     a.Evergreen,
+		"filters":       nil,
+		"name":          a.Name,
+		"project":       nil,
+		"resource_id":   schema.StringValue(a.ResourceId),
+		"resource_type": schema.StringValue(a.ResourceType),
+		"updated_at":    legacyTimePtr(a.UpdatedAt),
+		"weblink":       schema.StringValue(a.Weblink),
+	}
+	if a.Evergreen {
+		doc["evergreen_last_reminder_at"] = legacyTimePtr(a.EvergreenLastReminderAt)
+		doc["evergreen_owner"] = a.EvergreenOwner
+		doc["evergreen_reminder_interval"] = a.EvergreenReminderInterval
+	}
+	if a.Filters != nil {
+		filters := make([]interface{}, len(a.Filters))
+		for i, f := range a.Filters {
+			filters[i] = map[string]interface{}{
+				"description": schema.StringValue(f.Description),
+				"expression":  f.Expression,
+				"id":          f.Id,
+				"name":        f.Name,
+				"type":        f.Type,
+			}
+		}
+		doc["filters"] = filters
+	}
+	if a.Project != nil {
+		doc["project"] = map[string]interface{}{
+			"color":       schema.StringValue(a.Project.Color),
+			"default":     a.Project.Default,
+			"description": schema.StringValue(a.Project.Description),
+			"id":          a.Project.Id,
+			"name":        a.Project.Name,
+		}
+	}
+	return doc
+}
+
+// legacyTime formats t in UTC without time zone, a zero time is left out
+func legacyTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(schema.LegacyDateTimeFormat)
+}
+
+func legacyTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return legacyTime(*t)
+}
+
+// dateTimeKeys are the properties of an update and its artifacts that hold a date-time
+var dateTimeKeys = map[string]bool{"created_at": true, "updated_at": true, "evergreen_last_reminder_at": true}
+
+// WithLegacyTimes returns an adapter that encodes like a, with the timestamps in schema.LegacyDateTimeFormat for
+// Dispatch versions that don't accept a time zone. Only the JSON sent to Dispatch changes, the state workflows
+// keep in Temporal stays RFC 3339.
+func WithLegacyTimes(a Adapter) Adapter {
+	return legacyTimesAdapter{a}
+}
+
+type legacyTimesAdapter struct {
+	Adapter
+}
+
+func (a legacyTimesAdapter) Encode(update *schema.WorkflowInstanceUpdate) ([]byte, error) {
+	b, err := a.Adapter.Encode(update)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(rewriteTimes(v))
+}
+
+// rewriteTimes formats the RFC 3339 date-times in the decoded JSON v in the legacy format
+func rewriteTimes(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if s, ok := e.(string); ok && dateTimeKeys[k] {
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					v[k] = legacyTime(t)
+				}
+				continue
+			}
+			v[k] = rewriteTimes(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = rewriteTimes(e)
+		}
+	}
+	return v
+}
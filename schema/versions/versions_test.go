@@ -0,0 +1,144 @@
+package versions
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testUpdate() *schema.WorkflowInstanceUpdate {
+	created := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	return &schema.WorkflowInstanceUpdate{
+		CreatedAt:  created,
+		UpdatedAt:  created.Add(30 * time.Second),
+		Status:     schema.WorkflowInstanceStatusRunning,
+		ResourceId: schema.String("8d7c3b4a-run"),
+		Artifacts: []*schema.DocumentCreate{
+			{Name: "n02085620_7.jpg", CreatedAt: schema.Time(created), Description: schema.String("Dog 1")},
+		},
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"", Latest, Legacy} {
+		if _, err := Lookup(name); err != nil {
+			t.Errorf("Lookup(%q): %v", name, err)
+		}
+	}
+	if _, err := Lookup("v0"); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
+
+func TestLatest(t *testing.T) {
+	a, _ := Lookup(Latest)
+	got, err := a.Encode(testUpdate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(testUpdate())
+	if string(got) != string(want) {
+		t.Errorf("latest changed the update\n got: %s\nwant: %s", got, want)
+	}
+}
+
+// legacyUpdates are the updates of the files in testdata. The files are the output of the API of td at commit
+// 9b6b6ab for the same updates and must not be regenerated.
+func legacyUpdates() map[string]*schema.WorkflowInstanceUpdate {
+	created := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	at := func(seconds int) *time.Time {
+		return schema.Time(created.Add(time.Duration(seconds) * time.Second))
+	}
+	return map[string]*schema.WorkflowInstanceUpdate{
+		"created": {
+			CreatedAt: created,
+			UpdatedAt: created,
+			Status:    schema.WorkflowInstanceStatusCreated,
+			Weblink:   schema.String("https://dog.ceo/"),
+		},
+		"completed": {
+			CreatedAt:    created,
+			UpdatedAt:    *at(30),
+			ResourceId:   schema.String("inc-1"),
+			ResourceType: schema.String("incident"),
+			RunReason:    schema.String("on call"),
+			Status:       schema.WorkflowInstanceStatusCompleted,
+			Weblink:      schema.String("https://dog.ceo/"),
+			Artifacts: []*schema.DocumentCreate{
+				{
+					CreatedAt:    at(10),
+					UpdatedAt:    at(10),
+					Description:  schema.String("Dog 1"),
+					Name:         "n02088094_1007.jpg",
+					ResourceId:   schema.String("dogs/n02088094_1007.jpg"),
+					ResourceType: schema.String("image"),
+					Weblink:      schema.String("https://images.dog.ceo/breeds/hound-afghan/n02088094_1007.jpg"),
+				},
+				{
+					CreatedAt:                 at(20),
+					UpdatedAt:                 at(25),
+					Description:               schema.String("Runbook"),
+					Evergreen:                 true,
+					EvergreenLastReminderAt:   at(25),
+					EvergreenOwner:            "owner@example.com",
+					EvergreenReminderInterval: 90,
+					Name:                      "runbook",
+					Filters: []*schema.SearchFilterRead{
+						{Description: schema.String("dogs"), Expression: []map[string]interface{}{}, Id: 3, Name: "dogs", Type: "Incident"},
+					},
+					Project: &schema.ProjectRead{
+						Color: schema.String("#ff0000"), Default: true, Description: schema.String("Default project"), Id: 1, Name: "default",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	a, _ := Lookup(Legacy)
+	for name, u := range legacyUpdates() {
+		got, err := a.Encode(u)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want, err := ioutil.ReadFile(filepath.Join("testdata", name+".golden.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from the output of td before the schema\n got: %s\nwant: %s", name, got, want)
+		}
+	}
+}
+
+func TestWithLegacyTimes(t *testing.T) {
+	latest, _ := Lookup(Latest)
+	b, err := WithLegacyTimes(latest).Encode(testUpdate())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["created_at"] != "2021-10-01 12:00:00" || got["updated_at"] != "2021-10-01 12:00:30" || got["resource_type"] != nil {
+		t.Errorf("unexpected update %s", b)
+	}
+	artifact := got["artifacts"].([]interface{})[0].(map[string]interface{})
+	if artifact["created_at"] != "2021-10-01 12:00:00" || artifact["updated_at"] != nil {
+		t.Errorf("unexpected artifact %v", artifact)
+	}
+
+	// the state in Temporal keeps RFC 3339
+	state, _ := json.Marshal(testUpdate())
+	if !strings.Contains(string(state), `"created_at":"2021-10-01T12:00:00Z"`) {
+		t.Errorf("the schema types changed their format: %s", state)
+	}
+}
@@ -1,4 +1,4 @@
-// Code generated by schema-generate. DO NOT EDIT.
+// Code generated by schemagen. DO NOT EDIT.
 
 package schema
 
@@ -6,217 +6,83 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 )
 
 // DocumentCreate
 type DocumentCreate struct {
-	CreatedAt                 string              `json:"created_at,omitempty"`
-	Description               string              `json:"description,omitempty"`
+	CreatedAt                 *time.Time          `json:"created_at,omitempty"`
+	Description               *string             `json:"description,omitempty"`
 	Evergreen                 bool                `json:"evergreen,omitempty"`
-	EvergreenLastReminderAt   string              `json:"evergreen_last_reminder_at,omitempty"`
+	EvergreenLastReminderAt   *time.Time          `json:"evergreen_last_reminder_at,omitempty"`
 	EvergreenOwner            string              `json:"evergreen_owner,omitempty"`
 	EvergreenReminderInterval int                 `json:"evergreen_reminder_interval,omitempty"`
 	Filters                   []*SearchFilterRead `json:"filters,omitempty"`
 	Name                      string              `json:"name"`
 	Project                   *ProjectRead        `json:"project,omitempty"`
-	ResourceId                string              `json:"resource_id,omitempty"`
-	ResourceType              string              `json:"resource_type,omitempty"`
-	UpdatedAt                 string              `json:"updated_at,omitempty"`
-	Weblink                   string              `json:"weblink,omitempty"`
+	ResourceId                *string             `json:"resource_id,omitempty"`
+	ResourceType              *string             `json:"resource_type,omitempty"`
+	UpdatedAt                 *time.Time          `json:"updated_at,omitempty"`
+	Weblink                   *string             `json:"weblink,omitempty"`
 }
 
-// ExpressionItems
-type ExpressionItems struct {
-}
-
-// ParametersItems
-type ParametersItems struct {
-}
-
-// ProjectRead
-type ProjectRead struct {
-	Color       string `json:"color,omitempty"`
-	Default     bool   `json:"default,omitempty"`
-	Description string `json:"description,omitempty"`
-	Id          int    `json:"id,omitempty"`
-	Name        string `json:"name"`
-}
-
-// SearchFilterRead
-type SearchFilterRead struct {
-	Description string             `json:"description,omitempty"`
-	Expression  []*ExpressionItems `json:"expression"`
-	Id          int                `json:"id"`
-	Name        string             `json:"name"`
-	Type        string             `json:"type,omitempty"`
-}
-
-// WorkflowInstanceUpdate
-type WorkflowInstanceUpdate struct {
-	Artifacts    []*DocumentCreate  `json:"artifacts,omitempty"`
-	CreatedAt    string             `json:"created_at,omitempty"`
-	Parameters   []*ParametersItems `json:"parameters,omitempty"`
-	ResourceId   string             `json:"resource_id,omitempty"`
-	ResourceType string             `json:"resource_type,omitempty"`
-	RunReason    string             `json:"run_reason,omitempty"`
-	Status       string             `json:"status,omitempty"`
-	UpdatedAt    string             `json:"updated_at,omitempty"`
-	Weblink      string             `json:"weblink,omitempty"`
-}
-
-func (strct *DocumentCreate) MarshalJSON() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0))
-	buf.WriteString("{")
-	comma := false
-	// Marshal the "created_at" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"created_at\": ")
-	if tmp, err := json.Marshal(strct.CreatedAt); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "description" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"description\": ")
-	if tmp, err := json.Marshal(strct.Description); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "evergreen" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"evergreen\": ")
-	if tmp, err := json.Marshal(strct.Evergreen); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "evergreen_last_reminder_at" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"evergreen_last_reminder_at\": ")
-	if tmp, err := json.Marshal(strct.EvergreenLastReminderAt); err != nil {
-		return nil, err
+func (strct DocumentCreate) MarshalJSON() ([]byte, error) {
+	obj := &jsonObject{}
+	if strct.CreatedAt == nil {
+		obj.null("created_at")
 	} else {
-		buf.Write(tmp)
+		obj.dateTime("created_at", *strct.CreatedAt)
 	}
-	comma = true
-	// Marshal the "evergreen_owner" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"evergreen_owner\": ")
-	if tmp, err := json.Marshal(strct.EvergreenOwner); err != nil {
-		return nil, err
+	if strct.Description == nil {
+		obj.null("description")
 	} else {
-		buf.Write(tmp)
+		obj.field("description", strct.Description)
 	}
-	comma = true
-	// Marshal the "evergreen_reminder_interval" field
-	if comma {
-		buf.WriteString(",")
+	if strct.Evergreen {
+		obj.field("evergreen", strct.Evergreen)
 	}
-	buf.WriteString("\"evergreen_reminder_interval\": ")
-	if tmp, err := json.Marshal(strct.EvergreenReminderInterval); err != nil {
-		return nil, err
+	if strct.EvergreenLastReminderAt == nil {
+		obj.null("evergreen_last_reminder_at")
 	} else {
-		buf.Write(tmp)
+		obj.dateTime("evergreen_last_reminder_at", *strct.EvergreenLastReminderAt)
 	}
-	comma = true
-	// Marshal the "filters" field
-	if comma {
-		buf.WriteString(",")
+	if strct.EvergreenOwner != "" {
+		obj.field("evergreen_owner", strct.EvergreenOwner)
 	}
-	buf.WriteString("\"filters\": ")
-	if tmp, err := json.Marshal(strct.Filters); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// "Name" field is required
-	// only required object types supported for marshal checking (for now)
-	// Marshal the "name" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"name\": ")
-	if tmp, err := json.Marshal(strct.Name); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
+	if strct.EvergreenReminderInterval != 0 {
+		obj.field("evergreen_reminder_interval", strct.EvergreenReminderInterval)
 	}
-	comma = true
-	// Marshal the "project" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"project\": ")
-	if tmp, err := json.Marshal(strct.Project); err != nil {
-		return nil, err
+	if strct.Filters == nil {
+		obj.raw("filters", "[]")
 	} else {
-		buf.Write(tmp)
+		obj.field("filters", strct.Filters)
 	}
-	comma = true
-	// Marshal the "resource_id" field
-	if comma {
-		buf.WriteString(",")
+	obj.field("name", strct.Name)
+	if strct.Project != nil {
+		obj.field("project", strct.Project)
 	}
-	buf.WriteString("\"resource_id\": ")
-	if tmp, err := json.Marshal(strct.ResourceId); err != nil {
-		return nil, err
+	if strct.ResourceId == nil {
+		obj.null("resource_id")
 	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "resource_type" field
-	if comma {
-		buf.WriteString(",")
+		obj.field("resource_id", strct.ResourceId)
 	}
-	buf.WriteString("\"resource_type\": ")
-	if tmp, err := json.Marshal(strct.ResourceType); err != nil {
-		return nil, err
+	if strct.ResourceType == nil {
+		obj.null("resource_type")
 	} else {
-		buf.Write(tmp)
+		obj.field("resource_type", strct.ResourceType)
 	}
-	comma = true
-	// Marshal the "updated_at" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"updated_at\": ")
-	if tmp, err := json.Marshal(strct.UpdatedAt); err != nil {
-		return nil, err
+	if strct.UpdatedAt == nil {
+		obj.null("updated_at")
 	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "weblink" field
-	if comma {
-		buf.WriteString(",")
+		obj.dateTime("updated_at", *strct.UpdatedAt)
 	}
-	buf.WriteString("\"weblink\": ")
-	if tmp, err := json.Marshal(strct.Weblink); err != nil {
-		return nil, err
+	if strct.Weblink == nil {
+		obj.null("weblink")
 	} else {
-		buf.Write(tmp)
+		obj.field("weblink", strct.Weblink)
 	}
-	comma = true
-
-	buf.WriteString("}")
-	rv := buf.Bytes()
-	return rv, nil
+	return obj.bytes()
 }
 
 func (strct *DocumentCreate) UnmarshalJSON(b []byte) error {
@@ -229,9 +95,15 @@ func (strct *DocumentCreate) UnmarshalJSON(b []byte) error {
 	for k, v := range jsonMap {
 		switch k {
 		case "created_at":
-			if err := json.Unmarshal([]byte(v), &strct.CreatedAt); err != nil {
+			t, err := parseDateTime(v)
+			if err != nil {
 				return err
 			}
+			if t.IsZero() {
+				strct.CreatedAt = nil
+			} else {
+				strct.CreatedAt = &t
+			}
 		case "description":
 			if err := json.Unmarshal([]byte(v), &strct.Description); err != nil {
 				return err
@@ -241,9 +113,15 @@ func (strct *DocumentCreate) UnmarshalJSON(b []byte) error {
 				return err
 			}
 		case "evergreen_last_reminder_at":
-			if err := json.Unmarshal([]byte(v), &strct.EvergreenLastReminderAt); err != nil {
+			t, err := parseDateTime(v)
+			if err != nil {
 				return err
 			}
+			if t.IsZero() {
+				strct.EvergreenLastReminderAt = nil
+			} else {
+				strct.EvergreenLastReminderAt = &t
+			}
 		case "evergreen_owner":
 			if err := json.Unmarshal([]byte(v), &strct.EvergreenOwner); err != nil {
 				return err
@@ -274,9 +152,15 @@ func (strct *DocumentCreate) UnmarshalJSON(b []byte) error {
 				return err
 			}
 		case "updated_at":
-			if err := json.Unmarshal([]byte(v), &strct.UpdatedAt); err != nil {
+			t, err := parseDateTime(v)
+			if err != nil {
 				return err
 			}
+			if t.IsZero() {
+				strct.UpdatedAt = nil
+			} else {
+				strct.UpdatedAt = &t
+			}
 		case "weblink":
 			if err := json.Unmarshal([]byte(v), &strct.Weblink); err != nil {
 				return err
@@ -290,71 +174,33 @@ func (strct *DocumentCreate) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (strct *ProjectRead) MarshalJSON() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0))
-	buf.WriteString("{")
-	comma := false
-	// Marshal the "color" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"color\": ")
-	if tmp, err := json.Marshal(strct.Color); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "default" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"default\": ")
-	if tmp, err := json.Marshal(strct.Default); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "description" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"description\": ")
-	if tmp, err := json.Marshal(strct.Description); err != nil {
-		return nil, err
+// ProjectRead
+type ProjectRead struct {
+	Color       *string `json:"color,omitempty"`
+	Default     bool    `json:"default,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Id          int     `json:"id,omitempty"`
+	Name        string  `json:"name"`
+}
+
+func (strct ProjectRead) MarshalJSON() ([]byte, error) {
+	obj := &jsonObject{}
+	if strct.Color == nil {
+		obj.null("color")
 	} else {
-		buf.Write(tmp)
+		obj.field("color", strct.Color)
 	}
-	comma = true
-	// Marshal the "id" field
-	if comma {
-		buf.WriteString(",")
+	if strct.Default {
+		obj.field("default", strct.Default)
 	}
-	buf.WriteString("\"id\": ")
-	if tmp, err := json.Marshal(strct.Id); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// "Name" field is required
-	// only required object types supported for marshal checking (for now)
-	// Marshal the "name" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"name\": ")
-	if tmp, err := json.Marshal(strct.Name); err != nil {
-		return nil, err
+	if strct.Description == nil {
+		obj.null("description")
 	} else {
-		buf.Write(tmp)
+		obj.field("description", strct.Description)
 	}
-	comma = true
-
-	buf.WriteString("}")
-	rv := buf.Bytes()
-	return rv, nil
+	obj.field("id", strct.Id)
+	obj.field("name", strct.Name)
+	return obj.bytes()
 }
 
 func (strct *ProjectRead) UnmarshalJSON(b []byte) error {
@@ -396,75 +242,27 @@ func (strct *ProjectRead) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (strct *SearchFilterRead) MarshalJSON() ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0))
-	buf.WriteString("{")
-	comma := false
-	// Marshal the "description" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"description\": ")
-	if tmp, err := json.Marshal(strct.Description); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// "Expression" field is required
-	// only required object types supported for marshal checking (for now)
-	// Marshal the "expression" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"expression\": ")
-	if tmp, err := json.Marshal(strct.Expression); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// "Id" field is required
-	// only required object types supported for marshal checking (for now)
-	// Marshal the "id" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"id\": ")
-	if tmp, err := json.Marshal(strct.Id); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// "Name" field is required
-	// only required object types supported for marshal checking (for now)
-	// Marshal the "name" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"name\": ")
-	if tmp, err := json.Marshal(strct.Name); err != nil {
-		return nil, err
-	} else {
-		buf.Write(tmp)
-	}
-	comma = true
-	// Marshal the "type" field
-	if comma {
-		buf.WriteString(",")
-	}
-	buf.WriteString("\"type\": ")
-	if tmp, err := json.Marshal(strct.Type); err != nil {
-		return nil, err
+// SearchFilterRead
+type SearchFilterRead struct {
+	Description *string                  `json:"description,omitempty"`
+	Expression  []map[string]interface{} `json:"expression"`
+	Id          int                      `json:"id"`
+	Name        string                   `json:"name"`
+	Type        string                   `json:"type,omitempty"`
+}
+
+func (strct SearchFilterRead) MarshalJSON() ([]byte, error) {
+	obj := &jsonObject{}
+	if strct.Description == nil {
+		obj.null("description")
 	} else {
-		buf.Write(tmp)
+		obj.field("description", strct.Description)
 	}
-	comma = true
-
-	buf.WriteString("}")
-	rv := buf.Bytes()
-	return rv, nil
+	obj.field("expression", strct.Expression)
+	obj.field("id", strct.Id)
+	obj.field("name", strct.Name)
+	obj.field("type", strct.Type)
+	return obj.bytes()
 }
 
 func (strct *SearchFilterRead) UnmarshalJSON(b []byte) error {
@@ -517,3 +315,242 @@ func (strct *SearchFilterRead) UnmarshalJSON(b []byte) error {
 	}
 	return nil
 }
+
+// WorkflowInstanceStatus An enumeration.
+type WorkflowInstanceStatus string
+
+// Values of WorkflowInstanceStatus
+const (
+	WorkflowInstanceStatusSubmitted WorkflowInstanceStatus = "Submitted"
+	WorkflowInstanceStatusCreated   WorkflowInstanceStatus = "Created"
+	WorkflowInstanceStatusRunning   WorkflowInstanceStatus = "Running"
+	WorkflowInstanceStatusCompleted WorkflowInstanceStatus = "Completed"
+	WorkflowInstanceStatusFailed    WorkflowInstanceStatus = "Failed"
+)
+
+// Valid returns true when the value is one of the values of the enumeration
+func (e WorkflowInstanceStatus) Valid() bool {
+	switch e {
+	case WorkflowInstanceStatusSubmitted, WorkflowInstanceStatusCreated, WorkflowInstanceStatusRunning, WorkflowInstanceStatusCompleted, WorkflowInstanceStatusFailed:
+		return true
+	}
+	return false
+}
+
+func (e WorkflowInstanceStatus) MarshalJSON() ([]byte, error) {
+	if !e.Valid() {
+		return nil, fmt.Errorf("invalid WorkflowInstanceStatus %q", string(e))
+	}
+	return json.Marshal(string(e))
+}
+
+func (e *WorkflowInstanceStatus) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	// an empty value is accepted so payloads written before the value was set can still be read
+	if v := WorkflowInstanceStatus(s); v == "" || v.Valid() {
+		*e = v
+		return nil
+	}
+	return fmt.Errorf("invalid WorkflowInstanceStatus %q", s)
+}
+
+// WorkflowInstanceUpdate
+type WorkflowInstanceUpdate struct {
+	Artifacts    []*DocumentCreate        `json:"artifacts,omitempty"`
+	CreatedAt    time.Time                `json:"created_at,omitempty"`
+	Parameters   []map[string]interface{} `json:"parameters,omitempty"`
+	ResourceId   *string                  `json:"resource_id,omitempty"`
+	ResourceType *string                  `json:"resource_type,omitempty"`
+	RunReason    *string                  `json:"run_reason,omitempty"`
+	Status       WorkflowInstanceStatus   `json:"status,omitempty"`
+	UpdatedAt    time.Time                `json:"updated_at,omitempty"`
+	Weblink      *string                  `json:"weblink,omitempty"`
+}
+
+func (strct WorkflowInstanceUpdate) MarshalJSON() ([]byte, error) {
+	obj := &jsonObject{}
+	if strct.Artifacts == nil {
+		obj.raw("artifacts", "[]")
+	} else {
+		obj.field("artifacts", strct.Artifacts)
+	}
+	if !strct.CreatedAt.IsZero() {
+		obj.dateTime("created_at", strct.CreatedAt)
+	}
+	if strct.Parameters == nil {
+		obj.raw("parameters", "[]")
+	} else {
+		obj.field("parameters", strct.Parameters)
+	}
+	if strct.ResourceId == nil {
+		obj.null("resource_id")
+	} else {
+		obj.field("resource_id", strct.ResourceId)
+	}
+	if strct.ResourceType == nil {
+		obj.null("resource_type")
+	} else {
+		obj.field("resource_type", strct.ResourceType)
+	}
+	if strct.RunReason == nil {
+		obj.null("run_reason")
+	} else {
+		obj.field("run_reason", strct.RunReason)
+	}
+	if strct.Status != "" {
+		obj.field("status", strct.Status)
+	}
+	if !strct.UpdatedAt.IsZero() {
+		obj.dateTime("updated_at", strct.UpdatedAt)
+	}
+	if strct.Weblink == nil {
+		obj.null("weblink")
+	} else {
+		obj.field("weblink", strct.Weblink)
+	}
+	return obj.bytes()
+}
+
+func (strct *WorkflowInstanceUpdate) UnmarshalJSON(b []byte) error {
+	var jsonMap map[string]json.RawMessage
+	if err := json.Unmarshal(b, &jsonMap); err != nil {
+		return err
+	}
+	// parse all the defined properties
+	for k, v := range jsonMap {
+		switch k {
+		case "artifacts":
+			if err := json.Unmarshal([]byte(v), &strct.Artifacts); err != nil {
+				return err
+			}
+		case "created_at":
+			t, err := parseDateTime(v)
+			if err != nil {
+				return err
+			}
+			strct.CreatedAt = t
+		case "parameters":
+			if err := json.Unmarshal([]byte(v), &strct.Parameters); err != nil {
+				return err
+			}
+		case "resource_id":
+			if err := json.Unmarshal([]byte(v), &strct.ResourceId); err != nil {
+				return err
+			}
+		case "resource_type":
+			if err := json.Unmarshal([]byte(v), &strct.ResourceType); err != nil {
+				return err
+			}
+		case "run_reason":
+			if err := json.Unmarshal([]byte(v), &strct.RunReason); err != nil {
+				return err
+			}
+		case "status":
+			if err := json.Unmarshal([]byte(v), &strct.Status); err != nil {
+				return err
+			}
+		case "updated_at":
+			t, err := parseDateTime(v)
+			if err != nil {
+				return err
+			}
+			strct.UpdatedAt = t
+		case "weblink":
+			if err := json.Unmarshal([]byte(v), &strct.Weblink); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonObject writes the fields of a JSON object in the order they are added
+type jsonObject struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (o *jsonObject) key(name string) {
+	if o.buf.Len() == 0 {
+		o.buf.WriteString("{")
+	} else {
+		o.buf.WriteString(",")
+	}
+	k, _ := json.Marshal(name)
+	o.buf.Write(k)
+	o.buf.WriteString(":")
+}
+
+func (o *jsonObject) field(name string, v interface{}) {
+	if o.err != nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		o.err = fmt.Errorf("%s: %w", name, err)
+		return
+	}
+	o.key(name)
+	o.buf.Write(b)
+}
+
+func (o *jsonObject) raw(name, v string) {
+	o.key(name)
+	o.buf.WriteString(v)
+}
+
+func (o *jsonObject) null(name string) {
+	o.raw(name, "null")
+}
+
+func (o *jsonObject) dateTime(name string, t time.Time) {
+	o.field(name, t.UTC().Format(DateTimeFormat))
+}
+
+func (o *jsonObject) bytes() ([]byte, error) {
+	if o.err != nil {
+		return nil, o.err
+	}
+	if o.buf.Len() == 0 {
+		return []byte("{}"), nil
+	}
+	o.buf.WriteString("}")
+	return o.buf.Bytes(), nil
+}
+
+// DateTimeFormat is the layout date-time values are written in. It defaults to RFC 3339 as required by the
+// schema. Dispatch versions that can't handle a time zone need LegacyDateTimeFormat. Values are written in UTC.
+var DateTimeFormat = time.RFC3339Nano
+
+// LegacyDateTimeFormat is the layout without time zone that older Dispatch versions expect
+const LegacyDateTimeFormat = "2006-01-02 15:04:05"
+
+// dateTimeLayouts are the layouts date-time values are parsed with. Besides RFC 3339, Dispatch sends
+// timestamps without a time zone, which are taken as UTC.
+var dateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// parseDateTime parses a JSON string with a date-time. Empty strings and null result in the zero time.
+func parseDateTime(b []byte) (time.Time, error) {
+	var s *string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return time.Time{}, err
+	}
+	if s == nil || *s == "" {
+		return time.Time{}, nil
+	}
+	var err error
+	for _, layout := range dateTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, *s, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,263 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func goldenUpdates() map[string]*WorkflowInstanceUpdate {
+	created := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	updated := created.Add(30 * time.Second)
+
+	return map[string]*WorkflowInstanceUpdate{
+		"submitted": {
+			Status: WorkflowInstanceStatusSubmitted,
+		},
+		"running": {
+			CreatedAt:    created,
+			UpdatedAt:    created,
+			Status:       WorkflowInstanceStatusRunning,
+			ResourceId:   String("8d7c3b4a-run"),
+			ResourceType: String("temporal-workflow"),
+			Weblink:      String("http://localhost:8088/namespaces/default/workflows/random_dog-25/8d7c3b4a-run/history"),
+		},
+		"completed": {
+			CreatedAt:    created,
+			UpdatedAt:    updated,
+			Status:       WorkflowInstanceStatusCompleted,
+			ResourceId:   String("8d7c3b4a-run"),
+			ResourceType: String("temporal-workflow"),
+			RunReason:    String("done"),
+			Parameters:   []map[string]interface{}{{"key": "term", "value": "love"}},
+			Artifacts: []*DocumentCreate{
+				{
+					Name:        "n02085620_7.jpg",
+					Description: String("Dog 1"),
+					CreatedAt:   Time(created),
+					UpdatedAt:   Time(created),
+					ResourceId:  String("n02085620_7.jpg"),
+					Weblink:     String("https://images.dog.ceo/breeds/chihuahua/n02085620_7.jpg"),
+				},
+				{
+					Name:                      "runbook.md",
+					Evergreen:                 true,
+					EvergreenOwner:            "oncall@example.com",
+					EvergreenReminderInterval: 30,
+					EvergreenLastReminderAt:   Time(updated),
+					Project:                   &ProjectRead{Id: 1, Name: "default"},
+				},
+			},
+		},
+	}
+}
+
+func TestWorkflowInstanceUpdateGolden(t *testing.T) {
+	schemaDoc := loadSchema(t)
+
+	for name, u := range goldenUpdates() {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var doc interface{}
+			if err := json.Unmarshal(b, &doc); err != nil {
+				t.Fatal(err)
+			}
+			for _, err := range validate(schemaDoc, schemaDoc, doc, "") {
+				t.Error(err)
+			}
+
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, b, "", "  "); err != nil {
+				t.Fatal(err)
+			}
+			indented.WriteString("\n")
+
+			golden := filepath.Join("testdata", name+".golden.json")
+			if *update {
+				if err := ioutil.WriteFile(golden, indented.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(indented.Bytes(), want) {
+				t.Errorf("output differs from %s:\n%s", golden, indented.String())
+			}
+
+			// what we write has to come back the same
+			back := &WorkflowInstanceUpdate{}
+			if err := json.Unmarshal(b, back); err != nil {
+				t.Fatal(err)
+			}
+			again, err := json.Marshal(back)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, again) {
+				t.Errorf("round trip differs:\n%s\n%s", b, again)
+			}
+		})
+	}
+}
+
+func TestInvalidStatus(t *testing.T) {
+	if _, err := json.Marshal(&WorkflowInstanceUpdate{Status: "Paused"}); err == nil {
+		t.Error("expected an error for an invalid status")
+	}
+	if err := json.Unmarshal([]byte(`{"status": "Paused"}`), &WorkflowInstanceUpdate{}); err == nil {
+		t.Error("expected an error for an invalid status")
+	}
+}
+
+func TestLegacyTimestamps(t *testing.T) {
+	u := &WorkflowInstanceUpdate{}
+	err := json.Unmarshal([]byte(`{"created_at": "2021-10-01 12:00:00", "artifacts": [{"name": "dog", "created_at": ""}]}`), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.CreatedAt.Equal(time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("created_at = %s", u.CreatedAt)
+	}
+	if u.Artifacts[0].CreatedAt != nil {
+		t.Errorf("expected no created_at for artifact, got %s", u.Artifacts[0].CreatedAt)
+	}
+}
+
+func loadSchema(t *testing.T) map[string]interface{} {
+	t.Helper()
+	b, err := ioutil.ReadFile("dispatch-workflow.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s map[string]interface{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// validate checks a decoded JSON document against the parts of JSON Schema the Dispatch schema uses
+func validate(root, s map[string]interface{}, v interface{}, path string) []error {
+	if ref, ok := s["$ref"].(string); ok {
+		name := filepath.Base(ref)
+		return validate(root, root["definitions"].(map[string]interface{})[name].(map[string]interface{}), v, path)
+	}
+
+	if v == nil {
+		if s["nullable"] == true {
+			return nil
+		}
+		return []error{fmt.Errorf("%s: null is not allowed", path)}
+	}
+
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(path+": "+format, args...))
+	}
+
+	switch s["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			fail("expected an object")
+			break
+		}
+		if required, ok := s["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := obj[r.(string)]; !ok {
+					fail("%s is required", r)
+				}
+			}
+		}
+		props, _ := s["properties"].(map[string]interface{})
+		for k, pv := range obj {
+			if ps, ok := props[k].(map[string]interface{}); ok {
+				errs = append(errs, validate(root, ps, pv, path+"."+k)...)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			fail("expected an array")
+			break
+		}
+		if items, ok := s["items"].(map[string]interface{}); ok {
+			for i, item := range arr {
+				errs = append(errs, validate(root, items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("expected a string")
+			break
+		}
+		switch s["format"] {
+		case "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				fail("%q is not a date-time", str)
+			}
+		case "email":
+			if _, err := mail.ParseAddress(str); err != nil {
+				fail("%q is not an email address", str)
+			}
+		}
+		if min, ok := s["minLength"].(float64); ok && len(str) < int(min) {
+			fail("%q is shorter than %v", str, min)
+		}
+		if pattern, ok := s["pattern"].(string); ok && pattern == `^(?!\s*$).+` {
+			// Go doesn't support the negative lookahead Dispatch uses to reject blank strings
+			if regexp.MustCompile(`^\s*$`).MatchString(str) {
+				fail("%q is blank", str)
+			}
+		}
+		if enum, ok := s["enum"].([]interface{}); ok {
+			found := false
+			for _, e := range enum {
+				found = found || e == str
+			}
+			if !found {
+				fail("%q is not one of %v", str, enum)
+			}
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != float64(int64(n)) {
+			fail("expected an integer")
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("expected a boolean")
+		}
+	}
+	return errs
+}
+
+func TestLegacyDateTimeFormat(t *testing.T) {
+	defer func(format string) { DateTimeFormat = format }(DateTimeFormat)
+	DateTimeFormat = LegacyDateTimeFormat
+
+	cet := time.FixedZone("CET", 3600)
+	b, err := json.Marshal(&WorkflowInstanceUpdate{CreatedAt: time.Date(2021, 10, 1, 13, 0, 0, 0, cet)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"created_at":"2021-10-01 12:00:00"`)) {
+		t.Errorf("expected created_at in UTC without time zone, got %s", b)
+	}
+}
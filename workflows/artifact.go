@@ -0,0 +1,100 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/artifacts"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// artifactResourceType is reported to Dispatch as the type of artifacts kept in the artifact store
+	artifactResourceType = "td-artifact"
+	// artifactStoreNotConfigured is the error type returned when no artifact store is configured
+	artifactStoreNotConfigured = "ArtifactStoreNotConfigured"
+)
+
+// ArtifactUpload is a file produced by a workflow that is kept in the artifact store
+type ArtifactUpload struct {
+	// Name is the file name of the artifact, unique within the run
+	Name string
+	// ContentType of the content. Derived from the extension of the name when empty.
+	ContentType string
+	// Description is shown in Dispatch
+	Description string
+	// Content of the file. Activities that produce large files should use ArtifactStorage.Put instead, so
+	// the content doesn't become part of the workflow history.
+	Content []byte
+}
+
+// ArtifactStorage keeps files of workflow runs in the artifact store. Activities that produce files get it
+// from the Environment and call Put.
+type ArtifactStorage struct {
+	// Store keeps the files. Put fails when nil.
+	Store artifacts.Store
+	// Links signs the download links that become the weblink of stored artifacts when the state of a run is
+	// served. Artifacts have no weblink when nil.
+	Links *artifacts.Links
+	// Now returns the time the artifacts are created at. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Put stores the content as artifact of the workflow run of the activity in ctx and returns the artifact. The
+// artifact keeps the key of the file as its resource id, the signed download link expires and is only added when
+// the state is served.
+func (s *ArtifactStorage) Put(ctx context.Context, name, contentType, description string, r io.Reader) (*schema.DocumentCreate, error) {
+	if s == nil || s.Store == nil {
+		return nil, temporal.NewNonRetryableApplicationError("no artifact store configured", artifactStoreNotConfigured, nil)
+	}
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("invalid artifact name %q", name), "InvalidArtifactName", nil)
+	}
+
+	info := activity.GetInfo(ctx)
+	key := info.WorkflowExecution.ID + "/" + info.WorkflowExecution.RunID + "/" + name
+	obj, err := s.Store.Put(ctx, key, contentType, r)
+	if err != nil {
+		return nil, err
+	}
+	activity.GetLogger(ctx).Info("Stored artifact", "key", obj.Key, "size", obj.Size, "sha256", obj.SHA256)
+
+	now := currentTime(s.Now)
+	artifact := &schema.DocumentCreate{
+		CreatedAt:    schema.Time(now),
+		Description:  schema.String(description),
+		Name:         name,
+		ResourceId:   schema.String(obj.Key),
+		ResourceType: schema.String(artifactResourceType),
+		Filters:      []*schema.SearchFilterRead{},
+		UpdatedAt:    schema.Time(now),
+	}
+	return artifact, nil
+}
+
+// ArtifactActivities upload files produced by workflows to the artifact store
+type ArtifactActivities struct {
+	Storage *ArtifactStorage
+}
+
+// UploadArtifactActivity stores the upload and returns the artifact
+func (a *ArtifactActivities) UploadArtifactActivity(ctx context.Context, upload ArtifactUpload) (*schema.DocumentCreate, error) {
+	return a.Storage.Put(ctx, upload.Name, upload.ContentType, upload.Description, bytes.NewReader(upload.Content))
+}
+
+// UploadArtifact executes UploadArtifactActivity with the activity options of the workflow
+func UploadArtifact(ctx workflow.Context, upload ArtifactUpload) (*schema.DocumentCreate, error) {
+	var a *ArtifactActivities
+	ctx = workflow.WithActivityOptions(ctx, ActivityOptions(ctx, "UploadArtifactActivity"))
+	var artifact *schema.DocumentCreate
+	err := workflow.ExecuteActivity(ctx, a.UploadArtifactActivity, upload).Get(ctx, &artifact)
+	return artifact, err
+}
@@ -0,0 +1,67 @@
+package workflows_test
+
+import (
+	"context"
+	"github.com/jtorvald/temporal-dispatch-poc/artifacts"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"go.temporal.io/sdk/workflow"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func reportWorkflow(ctx workflow.Context, params map[string]interface{}) (*schema.DocumentCreate, error) {
+	return workflows.UploadArtifact(ctx, workflows.ArtifactUpload{
+		Name:        "report.txt",
+		Description: "Diagnostics report",
+		Content:     []byte("all good"),
+	})
+}
+
+func TestUploadArtifact(t *testing.T) {
+	store, err := artifacts.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := &artifacts.Links{BaseURL: "http://td.example.com", Secret: []byte("secret")}
+
+	env := workflowtest.New(t)
+	env.RegisterActivity(&workflows.ArtifactActivities{Storage: &workflows.ArtifactStorage{Store: store, Links: links}})
+	env.ExecuteWorkflow(reportWorkflow, workflowtest.Params(nil))
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatal(err)
+	}
+	var artifact *schema.DocumentCreate
+	if err := env.GetWorkflowResult(&artifact); err != nil {
+		t.Fatal(err)
+	}
+
+	key := schema.StringValue(artifact.ResourceId)
+	if !strings.HasSuffix(key, "/report.txt") || schema.StringValue(artifact.ResourceType) != "td-artifact" {
+		t.Errorf("unexpected artifact %s of type %s", key, schema.StringValue(artifact.ResourceType))
+	}
+	// the signed link expires, it is added when the state is served and not kept in the history
+	if artifact.Weblink != nil {
+		t.Errorf("the artifact keeps the weblink %s", schema.StringValue(artifact.Weblink))
+	}
+
+	r, _, err := store.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, _ := ioutil.ReadAll(r); string(data) != "all good" {
+		t.Errorf("stored %q", data)
+	}
+}
+
+func TestUploadArtifactNotConfigured(t *testing.T) {
+	env := workflowtest.New(t)
+	env.RegisterActivity(&workflows.ArtifactActivities{})
+	env.ExecuteWorkflow(reportWorkflow, workflowtest.Params(nil))
+	if err := env.GetWorkflowError(); err == nil || !strings.Contains(err.Error(), "no artifact store configured") {
+		t.Errorf("expected a not configured error, got %v", err)
+	}
+}
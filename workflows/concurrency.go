@@ -0,0 +1,404 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	filter "go.temporal.io/api/filter/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// workflowMemo is the memo key of the name a run was started under, runs of a schedule count as runs of the
+	// scheduled workflow
+	workflowMemo = "workflow"
+	// incidentMemo is the memo key of the incident a run was started for
+	incidentMemo = "incident_id"
+	// queueInterval is how often queued starts are retried
+	queueInterval = 5 * time.Second
+)
+
+// Concurrency limits the runs of a workflow that are open at the same time. Zero values don't limit. The workflows
+// a playbook runs as child workflows aren't limited, the playbook counts as one run.
+type Concurrency struct {
+	// MaxRuns is the maximum number of open runs of the workflow
+	MaxRuns int `json:"max_runs,omitempty"`
+	// MaxRunsPerIncident is the maximum number of open runs of the workflow for one incident. 1 prevents that the
+	// same workflow is started twice for an incident.
+	MaxRunsPerIncident int `json:"max_runs_per_incident,omitempty"`
+	// Queue starts the runs over a limit once other runs closed, instead of rejecting them. The queue is kept in
+	// memory, queued starts are lost when td stops.
+	Queue bool `json:"queue,omitempty"`
+}
+
+// merge returns the limits with the values that are set in o applied
+func (c Concurrency) merge(o Concurrency) Concurrency {
+	if o.MaxRuns != 0 {
+		c.MaxRuns = o.MaxRuns
+	}
+	if o.MaxRunsPerIncident != 0 {
+		c.MaxRunsPerIncident = o.MaxRunsPerIncident
+	}
+	if o.Queue {
+		c.Queue = true
+	}
+	return c
+}
+
+// LimitError is returned by Start when a limit of concurrent runs is reached
+type LimitError struct {
+	// Workflow is the name of the workflow that was started
+	Workflow string
+	// Reason describes the limit that was reached
+	Reason string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("unable to start %s: %s", e.Workflow, e.Reason)
+}
+
+// openRun is a workflow that was started through td and didn't close yet
+type openRun struct {
+	id       string
+	workflow string
+	incident string
+}
+
+// openRuns lists the open workflows of the types that were started through td. Only the listed types are paged
+// through, not all open executions of the namespace. Child workflows are left out, they are part of the run of
+// their parent.
+func (ws *WorkflowClient) openRuns(ctx context.Context, c client.Client, types []string) ([]openRun, error) {
+	var runs []openRun
+	for _, workflowType := range types {
+		typeRuns, err := ws.openRunsOfType(ctx, c, workflowType)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, typeRuns...)
+	}
+	return runs, nil
+}
+
+// openRunsOfType lists the open workflows of one workflow type
+func (ws *WorkflowClient) openRunsOfType(ctx context.Context, c client.Client, workflowType string) ([]openRun, error) {
+	earliest, latest := time.Unix(0, 0), time.Now()
+	req := &workflowservice.ListOpenWorkflowExecutionsRequest{
+		Namespace:       ws.namespace,
+		MaximumPageSize: 1000,
+		StartTimeFilter: &filter.StartTimeFilter{EarliestTime: &earliest, LatestTime: &latest},
+		Filters: &workflowservice.ListOpenWorkflowExecutionsRequest_TypeFilter{
+			TypeFilter: &filter.WorkflowTypeFilter{Name: workflowType},
+		},
+	}
+
+	var runs []openRun
+	for {
+		resp, err := c.ListOpenWorkflow(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range resp.GetExecutions() {
+			if info.GetParentExecution() != nil {
+				continue
+			}
+			run := openRun{id: info.GetExecution().GetWorkflowId()}
+			fields := info.GetMemo().GetFields()
+			if p, ok := fields[workflowMemo]; ok {
+				_ = converter.GetDefaultDataConverter().FromPayload(p, &run.workflow)
+			} else if def, ok := lookupByType(info.GetType().GetName()); ok {
+				// runs started before the memo was recorded
+				run.workflow = def.Name
+			} else {
+				continue
+			}
+			if p, ok := fields[incidentMemo]; ok {
+				_ = converter.GetDefaultDataConverter().FromPayload(p, &run.incident)
+			}
+			runs = append(runs, run)
+		}
+		if len(resp.GetNextPageToken()) == 0 {
+			return runs, nil
+		}
+		req.NextPageToken = resp.GetNextPageToken()
+	}
+}
+
+// countedTypes returns the workflow types whose open runs count towards the limits of def: its own type, the
+// schedules that may run it, and every registered type when the runs of all workflows are limited
+func (ws *WorkflowClient) countedTypes(def *Definition) []string {
+	types := map[string]bool{functionName(def.Workflow): true, functionName(ScheduleWorkflow): true}
+	if ws.maxRuns > 0 {
+		for _, d := range registry {
+			types[functionName(d.Workflow)] = true
+		}
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkLimits returns a LimitError when another run of the workflow for the incident would exceed the limits
+func checkLimits(runs []openRun, maxRuns int, name, incident string, limits Concurrency) error {
+	var total, workflow, perIncident int
+	for _, run := range runs {
+		total++
+		if run.workflow != name {
+			continue
+		}
+		workflow++
+		if incident != "" && run.incident == incident {
+			perIncident++
+		}
+	}
+	switch {
+	case limits.MaxRunsPerIncident > 0 && incident != "" && perIncident >= limits.MaxRunsPerIncident:
+		return &LimitError{Workflow: name, Reason: fmt.Sprintf("%d of %d runs for incident %s are open", perIncident, limits.MaxRunsPerIncident, incident)}
+	case limits.MaxRuns > 0 && workflow >= limits.MaxRuns:
+		return &LimitError{Workflow: name, Reason: fmt.Sprintf("%d of %d runs are open", workflow, limits.MaxRuns)}
+	case maxRuns > 0 && total >= maxRuns:
+		return &LimitError{Workflow: name, Reason: fmt.Sprintf("%d of %d runs of all workflows are open", total, maxRuns)}
+	}
+	return nil
+}
+
+// incidentKey returns the incident id of the params as string, JSON numbers are decoded as float64
+func incidentKey(params map[string]interface{}) string {
+	switch v := params[incidentMemo].(type) {
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	case int:
+		return strconv.Itoa(v)
+	case string:
+		return v
+	}
+	return ""
+}
+
+// admitAndExecute starts the workflow when the concurrency limits allow it and returns a LimitError otherwise.
+// ErrWorkflowAlreadyStarted is returned when a run with the id is open or being started. Temporal is called
+// without holding ws.mu, the start reserves its slot so concurrent starts don't both take the last free one.
+func (ws *WorkflowClient) admitAndExecute(ctx context.Context, c client.Client, def *Definition, id string, params map[string]interface{}, schedule *Schedule) (client.WorkflowRun, error) {
+	policy := policyFor(def, ws.policies)
+	incident := incidentKey(params)
+	limited := policy.Concurrency != (Concurrency{}) || ws.maxRuns > 0
+	if limited {
+		listed := time.Now()
+		runs, err := ws.openRuns(ctx, c, ws.countedTypes(def))
+		if err != nil {
+			return nil, fmt.Errorf("unable to list open workflows: %w", err)
+		}
+		if err := ws.reserve(id, listed, runs, def.Name, incident, policy.Concurrency); err != nil {
+			return nil, err
+		}
+	}
+
+	options := client.StartWorkflowOptions{
+		ID:                       id,
+		TaskQueue:                ws.queue,
+		WorkflowExecutionTimeout: time.Duration(policy.ExecutionTimeout),
+		WorkflowRunTimeout:       time.Duration(policy.RunTimeout),
+		// the memo reports the code version of runs that don't have a state, like runs that never started
+		Memo: map[string]interface{}{codeVersionParameter: def.Version, workflowMemo: def.Name},
+		// a second start of the same instance is rejected instead of returning the open run
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}
+	if incident != "" {
+		options.Memo[incidentMemo] = incident
+	}
+	var we client.WorkflowRun
+	var err error
+	if schedule != nil {
+		// the timeouts of the workflow apply to its runs, the schedule runs until it is stopped
+		options.WorkflowExecutionTimeout, options.WorkflowRunTimeout = 0, 0
+		we, err = c.ExecuteWorkflow(ctx, options, ScheduleWorkflow, ScheduleInput{
+			Workflow: def.Name,
+			Params:   params,
+			Schedule: *schedule,
+		})
+	} else {
+		we, err = c.ExecuteWorkflow(ctx, options, def.Workflow, params)
+	}
+	if limited {
+		ws.release(id, err == nil)
+	}
+	var started *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &started) {
+		return nil, ErrWorkflowAlreadyStarted
+	}
+	return we, err
+}
+
+// reservation is an admitted start. It counts against the limits until the open runs are listed after the run
+// started, as the runs it started before aren't listed yet.
+type reservation struct {
+	run openRun
+	// started is when the run started, zero while it is being started
+	started time.Time
+}
+
+// reserve admits the start of the workflow when the open runs, listed at listed, together with the reserved starts
+// stay within the limits
+func (ws *WorkflowClient) reserve(id string, listed time.Time, runs []openRun, name, incident string, limits Concurrency) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if r, ok := ws.reserved[id]; ok && r.started.IsZero() {
+		return ErrWorkflowAlreadyStarted
+	}
+	listedIDs := map[string]bool{}
+	for _, run := range runs {
+		listedIDs[run.id] = true
+	}
+	for rid, r := range ws.reserved {
+		switch {
+		case !r.started.IsZero() && r.started.Before(listed):
+			// the run is part of the listed runs
+			delete(ws.reserved, rid)
+		case !listedIDs[rid]:
+			runs = append(runs, r.run)
+		}
+	}
+	if err := checkLimits(runs, ws.maxRuns, name, incident, limits); err != nil {
+		return err
+	}
+	if ws.reserved == nil {
+		ws.reserved = map[string]*reservation{}
+	}
+	ws.reserved[id] = &reservation{run: openRun{id: id, workflow: name, incident: incident}}
+	return nil
+}
+
+// release ends the reservation of a start that failed, the reservation of a started run is kept until the next
+// listing includes the run
+func (ws *WorkflowClient) release(id string, started bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	r, ok := ws.reserved[id]
+	switch {
+	case !ok:
+	case started:
+		r.started = time.Now()
+	default:
+		delete(ws.reserved, id)
+	}
+}
+
+// queuedStart is a start that waits for other runs to close
+type queuedStart struct {
+	def      *Definition
+	id       string
+	params   map[string]interface{}
+	schedule *Schedule
+	state    *schema.WorkflowInstanceUpdate
+}
+
+// enqueue queues the start and returns its state. A start that is already queued isn't queued twice. The caller
+// holds ws.mu.
+func (ws *WorkflowClient) enqueue(start *queuedStart, reason *LimitError) *schema.WorkflowInstanceUpdate {
+	for _, q := range ws.queued {
+		if q.id == start.id {
+			return q.state
+		}
+	}
+	now := time.Now().UTC()
+	start.state = &schema.WorkflowInstanceUpdate{
+		Artifacts: []*schema.DocumentCreate{},
+		CreatedAt: now,
+		RunReason: schema.String(fmt.Sprintf("queued, %s", reason.Reason)),
+		Status:    schema.WorkflowInstanceStatusSubmitted,
+		UpdatedAt: now,
+	}
+	ws.queued = append(ws.queued, start)
+	log.Println("Queued workflow ID: ", start.id, reason)
+	if !ws.draining && ws.drain != nil {
+		ws.draining = true
+		go ws.drain()
+	}
+	return start.state
+}
+
+// queuedState returns the state of a queued start of the workflow id
+func (ws *WorkflowClient) queuedState(id string) (*schema.WorkflowInstanceUpdate, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, q := range ws.queued {
+		if q.id == id {
+			return q.state, true
+		}
+	}
+	return nil, false
+}
+
+// dequeue removes the queued start of the workflow id and reports whether it was queued
+func (ws *WorkflowClient) dequeue(id string) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for i, q := range ws.queued {
+		if q.id == id {
+			ws.queued = append(ws.queued[:i], ws.queued[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// drainQueue starts the queued starts in order as soon as the limits allow it, until the queue is empty. Starts
+// that fail for another reason than a limit are dropped from the queue.
+func (ws *WorkflowClient) drainQueue() {
+	ticker := time.NewTicker(queueInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c, err := ws.getClient()
+		if err != nil {
+			continue
+		}
+		for {
+			q, ok := ws.nextQueued()
+			if !ok {
+				return
+			}
+			_, err := ws.admitAndExecute(context.Background(), c, q.def, q.id, q.params, q.schedule)
+			var limit *LimitError
+			if errors.As(err, &limit) {
+				break
+			}
+			if err != nil {
+				log.Println("Unable to start queued workflow, it is dropped", q.id, err)
+			}
+			ws.removeQueued(q)
+		}
+	}
+}
+
+// nextQueued returns the first queued start. The drain stops when the queue is empty.
+func (ws *WorkflowClient) nextQueued() (*queuedStart, bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if len(ws.queued) == 0 {
+		ws.draining = false
+		return nil, false
+	}
+	return ws.queued[0], true
+}
+
+// removeQueued removes the start from the queue, unless it was removed already
+func (ws *WorkflowClient) removeQueued(start *queuedStart) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for i, q := range ws.queued {
+		if q == start {
+			ws.queued = append(ws.queued[:i], ws.queued[i+1:]...)
+			return
+		}
+	}
+}
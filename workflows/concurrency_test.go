@@ -0,0 +1,189 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	commonpb "go.temporal.io/api/common/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"testing"
+	"time"
+)
+
+func TestCheckLimits(t *testing.T) {
+	runs := []openRun{
+		{workflow: "random_dog", incident: "32"},
+		{workflow: "random_dog", incident: "33"},
+		{workflow: "random_unsplash", incident: "32"},
+	}
+	tests := []struct {
+		name     string
+		maxRuns  int
+		workflow string
+		incident string
+		limits   Concurrency
+		limited  bool
+	}{
+		{name: "no limits", workflow: "random_dog", incident: "32"},
+		{name: "per incident", workflow: "random_dog", incident: "32", limits: Concurrency{MaxRunsPerIncident: 1}, limited: true},
+		{name: "other incident", workflow: "random_dog", incident: "34", limits: Concurrency{MaxRunsPerIncident: 1}},
+		{name: "without incident", workflow: "random_dog", limits: Concurrency{MaxRunsPerIncident: 1}},
+		{name: "per workflow", workflow: "random_dog", incident: "34", limits: Concurrency{MaxRuns: 2}, limited: true},
+		{name: "other workflow", workflow: "random_unsplash", incident: "33", limits: Concurrency{MaxRuns: 2}},
+		{name: "global", maxRuns: 3, workflow: "playbook", incident: "34", limited: true},
+		{name: "below global", maxRuns: 4, workflow: "playbook", incident: "34"},
+	}
+	for _, test := range tests {
+		err := checkLimits(runs, test.maxRuns, test.workflow, test.incident, test.limits)
+		if _, limited := err.(*LimitError); limited != test.limited {
+			t.Errorf("%s: got error %v, want limited %t", test.name, err, test.limited)
+		}
+	}
+}
+
+func TestIncidentKey(t *testing.T) {
+	for _, v := range []interface{}{float64(32), 32, "32"} {
+		if got := incidentKey(map[string]interface{}{"incident_id": v}); got != "32" {
+			t.Errorf("incident key of %#v is %q", v, got)
+		}
+	}
+	if got := incidentKey(map[string]interface{}{}); got != "" {
+		t.Errorf("incident key without incident is %q", got)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	drains := make(chan struct{}, 2)
+	ws := &WorkflowClient{drain: func() { drains <- struct{}{} }}
+	def, _ := lookup("random_dog")
+	limit := &LimitError{Workflow: "random_dog", Reason: "1 of 1 runs for incident 32 are open"}
+
+	state := ws.enqueue(&queuedStart{def: def, id: "random_dog-25"}, limit)
+	if again := ws.enqueue(&queuedStart{def: def, id: "random_dog-25"}, limit); again != state || len(ws.queued) != 1 {
+		t.Error("a queued start is queued twice")
+	}
+	ws.enqueue(&queuedStart{def: def, id: "random_dog-26"}, limit)
+	<-drains
+	if len(drains) != 0 {
+		t.Error("the queue is drained twice")
+	}
+	if q, ok := ws.nextQueued(); !ok || q.id != "random_dog-25" {
+		t.Errorf("next queued start is %+v", q)
+	}
+	ws.removeQueued(ws.queued[1])
+	if got, queued := ws.queuedState("random_dog-25"); !queued || got.Status != schema.WorkflowInstanceStatusSubmitted {
+		t.Errorf("queued state is %+v", got)
+	}
+	if !ws.dequeue("random_dog-25") || ws.dequeue("random_dog-25") {
+		t.Error("the queued start is not removed once")
+	}
+	if _, queued := ws.queuedState("random_dog-25"); queued {
+		t.Error("the start is still queued")
+	}
+}
+
+func TestReserve(t *testing.T) {
+	ws := &WorkflowClient{}
+	limits := Concurrency{MaxRuns: 1}
+	listed := time.Now()
+
+	if err := ws.reserve("random_dog-25", listed, nil, "random_dog", "32", limits); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.reserve("random_dog-25", listed, nil, "random_dog", "32", limits); err != ErrWorkflowAlreadyStarted {
+		t.Errorf("a start of the same instance returned %v", err)
+	}
+	var limit *LimitError
+	if err := ws.reserve("random_dog-26", listed, nil, "random_dog", "33", limits); !errors.As(err, &limit) {
+		t.Errorf("a start over the limit returned %v", err)
+	}
+
+	// the started run counts until it is listed, but not twice
+	ws.release("random_dog-25", true)
+	if err := ws.reserve("random_dog-26", listed, nil, "random_dog", "33", limits); !errors.As(err, &limit) {
+		t.Errorf("a start before the run is listed returned %v", err)
+	}
+	runs := []openRun{{id: "random_dog-25", workflow: "random_dog", incident: "32"}}
+	if err := ws.reserve("random_dog-26", listed, runs, "random_dog", "33", Concurrency{MaxRuns: 2}); err != nil {
+		t.Errorf("the listed run is counted twice: %v", err)
+	}
+	ws.release("random_dog-26", false)
+	if err := ws.reserve("random_dog-27", time.Now().Add(time.Second), nil, "random_dog", "34", limits); err != nil {
+		t.Errorf("the closed run still counts: %v", err)
+	}
+}
+
+// listClient lists the open runs by the workflow type of the request and counts the starts, which fail
+type listClient struct {
+	client.Client
+	open    map[string][]*workflowpb.WorkflowExecutionInfo
+	listed  []string
+	started int
+}
+
+func (c *listClient) ListOpenWorkflow(ctx context.Context, req *workflowservice.ListOpenWorkflowExecutionsRequest) (*workflowservice.ListOpenWorkflowExecutionsResponse, error) {
+	workflowType := req.GetTypeFilter().GetName()
+	c.listed = append(c.listed, workflowType)
+	return &workflowservice.ListOpenWorkflowExecutionsResponse{Executions: c.open[workflowType]}, nil
+}
+
+func (c *listClient) ExecuteWorkflow(ctx context.Context, options client.StartWorkflowOptions, workflow interface{}, args ...interface{}) (client.WorkflowRun, error) {
+	c.started++
+	return nil, errors.New("temporal is down")
+}
+
+// openExecution returns an open run of the workflow for the incident, with the memo td starts runs with
+func openExecution(id, workflow, incident string) *workflowpb.WorkflowExecutionInfo {
+	memo := map[string]*commonpb.Payload{}
+	memo[workflowMemo], _ = converter.GetDefaultDataConverter().ToPayload(workflow)
+	memo[incidentMemo], _ = converter.GetDefaultDataConverter().ToPayload(incident)
+	return &workflowpb.WorkflowExecutionInfo{
+		Execution: &commonpb.WorkflowExecution{WorkflowId: id, RunId: "run-1"},
+		Type:      &commonpb.WorkflowType{Name: functionName(RandomDogWorkflow)},
+		Memo:      &commonpb.Memo{Fields: memo},
+	}
+}
+
+func TestStartLimited(t *testing.T) {
+	c := &listClient{open: map[string][]*workflowpb.WorkflowExecutionInfo{
+		functionName(RandomDogWorkflow): {openExecution("random_dog-25", "random_dog", "32")},
+	}}
+	ws := &WorkflowClient{client: c, policies: map[string]Policy{"random_dog": {Concurrency: Concurrency{MaxRunsPerIncident: 1}}}}
+
+	_, err := ws.Start("random_dog", map[string]interface{}{"instance_id": 26, "incident_id": 32})
+	var limit *LimitError
+	if !errors.As(err, &limit) || limit.Workflow != "random_dog" {
+		t.Fatalf("Start returned %v, want a LimitError", err)
+	}
+	if c.started != 0 {
+		t.Error("the workflow was started over the limit")
+	}
+	// only the runs of the workflow and of the schedules that may run it are listed
+	if want := []string{"RandomDogWorkflow", "ScheduleWorkflow"}; fmt.Sprint(c.listed) != fmt.Sprint(want) {
+		t.Errorf("listed the types %v, want %v", c.listed, want)
+	}
+
+	// another incident is below the limit
+	if _, err := ws.Start("random_dog", map[string]interface{}{"instance_id": 27, "incident_id": 33}); errors.As(err, &limit) || c.started != 1 {
+		t.Errorf("the start for another incident returned %v", err)
+	}
+}
+
+func TestStartUnlimited(t *testing.T) {
+	c := &listClient{open: map[string][]*workflowpb.WorkflowExecutionInfo{
+		functionName(RandomDogWorkflow): {openExecution("random_dog-25", "random_dog", "32")},
+	}}
+	ws := &WorkflowClient{client: c}
+
+	// the workflows have no limits unless they are configured, so the open runs aren't listed
+	if _, err := ws.Start("random_dog", map[string]interface{}{"instance_id": 26, "incident_id": 32}); err == nil || c.started != 1 {
+		t.Fatalf("Start returned %v after %d starts, want the error of the start", err, c.started)
+	}
+	if len(c.listed) != 0 {
+		t.Errorf("listed the types %v without limits", c.listed)
+	}
+}
@@ -0,0 +1,56 @@
+package workflows
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Environment holds the dependencies of activities, so their outbound calls can be pointed to fakes. The worker
+// passes it to the Configure function of every registered definition, tests can set the fields of the activity
+// structs directly.
+type Environment struct {
+	// HTTPClient is used for outbound calls. Activities use their own client with a short timeout when nil.
+	HTTPClient *http.Client
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// BaseURLs overrides the base URL of external services by name, like "dog" or "unsplash"
+	BaseURLs map[string]string
+	// Artifacts keeps the files activities produce. Storing artifacts fails when nil.
+	Artifacts *ArtifactStorage
+	// Mirror makes fetch activities store a copy of the files they link to. Disabled when nil.
+	Mirror *Mirror
+}
+
+// baseURL returns the configured base URL of the service, or current when none is configured
+func (env Environment) baseURL(service, current string) string {
+	if u, ok := env.BaseURLs[service]; ok && u != "" {
+		return u
+	}
+	return current
+}
+
+// ParseBaseURLs parses a comma separated list of service=url pairs, like dog=http://localhost:9000/api
+func ParseBaseURLs(s string) (map[string]string, error) {
+	urls := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid base URL %q, expected service=url", pair)
+		}
+		urls[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return urls, nil
+}
+
+// currentTime returns the current time in UTC from the clock of an activity, or from time.Now when it has none
+func currentTime(clock func() time.Time) time.Time {
+	if clock == nil {
+		clock = time.Now
+	}
+	return clock().UTC()
+}
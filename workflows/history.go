@@ -0,0 +1,71 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"github.com/gogo/protobuf/jsonpb"
+	enums "go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/api/serviceerror"
+	"io"
+)
+
+// History is the recorded event history of a workflow run
+type History struct {
+	// Workflow is the name of the workflow in the registry, empty when the workflow type isn't registered
+	Workflow string
+	// WorkflowID is the Temporal workflow id of the run
+	WorkflowID string
+	// RunID is the Temporal run id
+	RunID string
+
+	history *historypb.History
+}
+
+// History returns the event history of a workflow run. An empty runID selects the latest run of the workflow.
+// ErrWorkflowNotFound is returned when Temporal doesn't know the workflow.
+func (ws *WorkflowClient) History(ctx context.Context, workflowID, runID string) (*History, error) {
+	c, err := ws.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if runID == "" {
+		desc, err := c.DescribeWorkflowExecution(ctx, workflowID, "")
+		if err != nil {
+			return nil, notFoundErr(err)
+		}
+		runID = desc.GetWorkflowExecutionInfo().GetExecution().GetRunId()
+	}
+
+	h := &History{WorkflowID: workflowID, RunID: runID, history: &historypb.History{}}
+	iter := c.GetWorkflowHistory(ctx, workflowID, runID, false, enums.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			return nil, notFoundErr(err)
+		}
+		if started := event.GetWorkflowExecutionStartedEventAttributes(); started != nil {
+			if def, ok := lookupByType(started.GetWorkflowType().GetName()); ok {
+				h.Workflow = def.Name
+			}
+		}
+		h.history.Events = append(h.history.Events, event)
+	}
+	return h, nil
+}
+
+// WriteJSON writes the history in the JSON format of the Temporal CLI, which the WorkflowReplayer reads
+func (h *History) WriteJSON(w io.Writer) error {
+	m := jsonpb.Marshaler{Indent: "  "}
+	return m.Marshal(w, h.history)
+}
+
+// notFoundErr maps the not found error of Temporal to ErrWorkflowNotFound
+func notFoundErr(err error) error {
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		return ErrWorkflowNotFound
+	}
+	return err
+}
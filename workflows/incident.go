@@ -0,0 +1,58 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/dispatch"
+	"go.temporal.io/sdk/temporal"
+	"strconv"
+)
+
+// dispatchNotConfigured is the error type returned by incident activities when no Dispatch API is configured
+const dispatchNotConfigured = "DispatchNotConfigured"
+
+// incidentActivities give workflows access to the incident they run for through the Dispatch API
+type incidentActivities struct {
+	dispatch *dispatch.Client
+}
+
+// FetchIncidentActivity returns the details of the incident from the incident_id parameter
+func (a *incidentActivities) FetchIncidentActivity(ctx context.Context, params map[string]interface{}) (*dispatch.Incident, error) {
+	incidentID, err := a.incidentID(params)
+	if err != nil {
+		return nil, err
+	}
+	incident, err := a.dispatch.GetIncident(ctx, incidentID)
+	if dispatch.IsNotFound(err) {
+		return nil, temporal.NewNonRetryableApplicationError(err.Error(), "IncidentNotFound", err)
+	}
+	return incident, err
+}
+
+// AddIncidentEventActivity adds an event to the timeline of the incident from the incident_id parameter
+func (a *incidentActivities) AddIncidentEventActivity(ctx context.Context, params map[string]interface{}, event *dispatch.Event) error {
+	incidentID, err := a.incidentID(params)
+	if err != nil {
+		return err
+	}
+	return a.dispatch.AddTimelineEvent(ctx, incidentID, event)
+}
+
+// incidentID returns the incident id from the workflow parameters
+func (a *incidentActivities) incidentID(params map[string]interface{}) (int, error) {
+	if a.dispatch == nil {
+		return 0, temporal.NewNonRetryableApplicationError("no Dispatch API configured", dispatchNotConfigured, nil)
+	}
+	switch v := params["incident_id"].(type) {
+	case float64:
+		return int(v), nil
+	case int:
+		return v, nil
+	case string:
+		if id, err := strconv.Atoi(v); err == nil {
+			return id, nil
+		}
+	}
+	return 0, temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("invalid incident_id %v", params["incident_id"]), "InvalidIncidentID", nil)
+}
@@ -0,0 +1,84 @@
+package workflows
+
+import (
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/dispatch"
+	"github.com/jtorvald/temporal-dispatch-poc/dispatch/dispatchtest"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+)
+
+func newIncidentActivities(t *testing.T) (*incidentActivities, *dispatchtest.Server) {
+	t.Helper()
+	srv := dispatchtest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.AddIncident(&dispatch.Incident{ID: 32, Name: "dispatch-default-default-32", Title: "Checkout is down"})
+	d, err := dispatch.NewClient(dispatch.Options{BaseURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &incidentActivities{dispatch: d}, srv
+}
+
+// assertErrorType fails the test when err isn't an application error of the type
+func assertErrorType(t *testing.T, err error, errType string) {
+	t.Helper()
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != errType {
+		t.Errorf("got error %v, want %s", err, errType)
+	}
+}
+
+func TestFetchIncidentActivity(t *testing.T) {
+	activities, _ := newIncidentActivities(t)
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	var a *incidentActivities
+	value, err := env.ExecuteActivity(a.FetchIncidentActivity, map[string]interface{}{"incident_id": float64(32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var incident *dispatch.Incident
+	if err := value.Get(&incident); err != nil {
+		t.Fatal(err)
+	}
+	if incident.ID != 32 || incident.Title != "Checkout is down" {
+		t.Errorf("incident is %+v", incident)
+	}
+
+	_, err = env.ExecuteActivity(a.FetchIncidentActivity, map[string]interface{}{"incident_id": "33"})
+	assertErrorType(t, err, "IncidentNotFound")
+	_, err = env.ExecuteActivity(a.FetchIncidentActivity, map[string]interface{}{"incident_id": "checkout"})
+	assertErrorType(t, err, "InvalidIncidentID")
+}
+
+func TestAddIncidentEventActivity(t *testing.T) {
+	activities, srv := newIncidentActivities(t)
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(activities)
+
+	var a *incidentActivities
+	event := &dispatch.Event{Source: "td", Description: "Heap dump taken"}
+	if _, err := env.ExecuteActivity(a.AddIncidentEventActivity, map[string]interface{}{"incident_id": 32}, event); err != nil {
+		t.Fatal(err)
+	}
+	if events := srv.Events(32); len(events) != 1 || events[0].Description != "Heap dump taken" {
+		t.Errorf("events are %+v", events)
+	}
+}
+
+func TestIncidentActivitiesNotConfigured(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(&incidentActivities{})
+
+	var a *incidentActivities
+	_, err := env.ExecuteActivity(a.FetchIncidentActivity, map[string]interface{}{"incident_id": 32})
+	assertErrorType(t, err, dispatchNotConfigured)
+	_, err = env.ExecuteActivity(a.AddIncidentEventActivity, map[string]interface{}{"incident_id": 32}, &dispatch.Event{})
+	assertErrorType(t, err, dispatchNotConfigured)
+}
@@ -0,0 +1,144 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"go.temporal.io/sdk/temporal"
+	"image"
+	_ "image/gif"  // register the decoder for image dimensions
+	_ "image/jpeg" // register the decoder for image dimensions
+	_ "image/png"  // register the decoder for image dimensions
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const (
+	// DefaultMirrorMaxBytes limits the size of mirrored files to 10 MB
+	DefaultMirrorMaxBytes = 10 << 20
+	// mirrorRejected is the error type of downloads that are too large or of a type that isn't accepted
+	mirrorRejected = "MirrorRejected"
+)
+
+// DefaultMirrorContentTypes are the media types that are mirrored by default
+var DefaultMirrorContentTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// Mirror downloads remote files into the artifact store, so artifacts don't depend on links that expire or
+// change. Fetch activities mirror the files they link to when they have a Mirror.
+type Mirror struct {
+	// Storage keeps the downloaded files
+	Storage *ArtifactStorage
+	// MaxBytes limits the size of downloads. Defaults to DefaultMirrorMaxBytes.
+	MaxBytes int64
+	// ContentTypes are the accepted media types. Defaults to DefaultMirrorContentTypes.
+	ContentTypes []string
+}
+
+// Copy downloads source with the client and stores it as the artifact. The returned artifact links to the
+// stored copy, and its description has the source, the dimensions of images, the size and the checksum.
+func (m *Mirror) Copy(ctx context.Context, c *http.Client, source string, artifact *schema.DocumentCreate) (*schema.DocumentCreate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, temporal.NewNonRetryableApplicationError("invalid source to mirror", mirrorRejected, err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return nil, m.reject("download of %s failed with status %d", source, resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("download of %s failed with status %d", source, resp.StatusCode)
+	}
+
+	maxBytes := m.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = DefaultMirrorMaxBytes
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, m.reject("%s is %d bytes, the limit is %d", source, resp.ContentLength, maxBytes)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, m.reject("%s is larger than the limit of %d bytes", source, maxBytes)
+	}
+
+	// the declared type has to match the content, so a mislabeled file isn't served as image
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("content-type"))
+	if sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data)); sniffed != contentType {
+		return nil, m.reject("%s is declared as %q but contains %q", source, contentType, sniffed)
+	}
+	if !m.accepts(contentType) {
+		return nil, m.reject("%s has content type %q, which is not mirrored", source, contentType)
+	}
+
+	details := fmt.Sprintf("mirrored from %s, %s, %d bytes", source, contentType, len(data))
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		details = fmt.Sprintf("mirrored from %s, %dx%d %s, %d bytes", source, cfg.Width, cfg.Height, contentType, len(data))
+	}
+
+	name := artifact.Name
+	if path.Ext(name) == "" {
+		name += extension(contentType)
+	}
+	description := strings.TrimSpace(schema.StringValue(artifact.Description))
+	stored, err := m.Storage.Put(ctx, name, contentType, description, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	stored.Description = schema.String(fmt.Sprintf("%s (%s, sha256 %x)", description, details, sum))
+	return stored, nil
+}
+
+// extension returns the common file extension of the content type
+func extension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png", "image/gif", "image/webp":
+		return "." + strings.TrimPrefix(contentType, "image/")
+	}
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+func (m *Mirror) accepts(contentType string) bool {
+	types := m.ContentTypes
+	if len(types) == 0 {
+		types = DefaultMirrorContentTypes
+	}
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mirror) reject(format string, args ...interface{}) error {
+	return temporal.NewNonRetryableApplicationError(fmt.Sprintf(format, args...), mirrorRejected, nil)
+}
+
+// setLabel replaces the description of the artifact with label, keeping the details a mirror added
+func setLabel(artifact *schema.DocumentCreate, label string) {
+	description := schema.StringValue(artifact.Description)
+	if i := strings.Index(description, " (mirrored from "); i >= 0 {
+		label += description[i:]
+	}
+	artifact.Description = schema.String(label)
+}
@@ -0,0 +1,86 @@
+package workflows_test
+
+import (
+	"context"
+	"github.com/jtorvald/temporal-dispatch-poc/artifacts"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testMirror(t *testing.T) (*workflows.Mirror, artifacts.Store) {
+	store, err := artifacts.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := &artifacts.Links{BaseURL: "http://td.example.com", Secret: []byte("secret")}
+	return &workflows.Mirror{Storage: &workflows.ArtifactStorage{Store: store, Links: links}}, store
+}
+
+func TestMirrorDog(t *testing.T) {
+	fake := workflowtest.NewDogAPI()
+	defer fake.Close()
+	mirror, store := testMirror(t)
+
+	env := workflowtest.New(t)
+	env.RegisterActivity(&workflows.DogActivities{BaseURL: fake.BaseURL(), HTTPClient: fake.Client(), Mirror: mirror})
+	result := env.Run(workflows.RandomDogWorkflow, workflowtest.Params(nil))
+	workflowtest.AssertArtifacts(t, result, "n02085620_1.jpg", "n02085620_2.jpg")
+
+	a := result.Artifacts[0]
+	if schema.StringValue(a.ResourceType) != "td-artifact" || a.Weblink != nil {
+		t.Errorf("artifact of type %s with weblink %s isn't the stored copy", schema.StringValue(a.ResourceType), schema.StringValue(a.Weblink))
+	}
+	details := regexp.MustCompile(`^Dog 1 \(mirrored from http://\S+/n02085620_1.jpg, 500x375 image/jpeg, \d+ bytes, sha256 [0-9a-f]{64}\)$`)
+	if !details.MatchString(schema.StringValue(a.Description)) {
+		t.Errorf("unexpected description %q", schema.StringValue(a.Description))
+	}
+	r, obj, err := store.Open(context.Background(), schema.StringValue(a.ResourceId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if obj.ContentType != "image/jpeg" || obj.Size == 0 {
+		t.Errorf("unexpected stored copy %+v", obj)
+	}
+}
+
+func TestMirrorUnsplash(t *testing.T) {
+	fake := workflowtest.NewUnsplash()
+	defer fake.Close()
+	mirror, _ := testMirror(t)
+
+	env := workflowtest.New(t)
+	env.RegisterActivity(&workflows.UnsplashActivities{BaseURL: fake.BaseURL(), HTTPClient: fake.Client(), Mirror: mirror})
+	result := env.Run(workflows.RandomUnsplashWorkflow, workflowtest.Params(nil))
+
+	// the photos get the extension of their content type
+	workflowtest.AssertArtifacts(t, result, "photo-1.jpg", "photo-2.jpg")
+	if !strings.Contains(schema.StringValue(result.Artifacts[1].Description), "400x320 image/jpeg") {
+		t.Errorf("unexpected description %q", schema.StringValue(result.Artifacts[1].Description))
+	}
+}
+
+func TestMirrorRejected(t *testing.T) {
+	fake := workflowtest.NewDogAPI()
+	defer fake.Close()
+
+	for name, mirror := range map[string]func(m *workflows.Mirror){
+		"too large":    func(m *workflows.Mirror) { m.MaxBytes = 100 },
+		"content type": func(m *workflows.Mirror) { m.ContentTypes = []string{"image/png"} },
+	} {
+		m, _ := testMirror(t)
+		mirror(m)
+		env := workflowtest.New(t)
+		env.RegisterActivity(&workflows.DogActivities{BaseURL: fake.BaseURL(), HTTPClient: fake.Client(), Mirror: m})
+		state, err := env.RunError(workflows.RandomDogWorkflow, workflowtest.Params(nil))
+		if err == nil {
+			t.Errorf("%s: expected the workflow to fail", name)
+			continue
+		}
+		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusFailed)
+	}
+}
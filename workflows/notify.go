@@ -0,0 +1,196 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"net/http"
+	"time"
+)
+
+// notifyActivityOptions bounds the retries of a callback so an unavailable endpoint delays the close of a
+// workflow for at most a minute
+var notifyActivityOptions = workflow.ActivityOptions{
+	StartToCloseTimeout:    10 * time.Second,
+	ScheduleToCloseTimeout: time.Minute,
+	RetryPolicy: &temporal.RetryPolicy{
+		InitialInterval:        time.Second,
+		BackoffCoefficient:     2,
+		MaximumAttempts:        5,
+		NonRetryableErrorTypes: []string{callbackRejected},
+	},
+}
+
+// callbackRejected is the error type of callbacks the endpoint refused and that should not be retried
+const callbackRejected = "CallbackRejected"
+
+// notification is the body posted to the callback URL. The update is encoded for the configured Dispatch version.
+type notification struct {
+	WorkflowID         string          `json:"workflow_id"`
+	WorkflowInstanceID interface{}     `json:"workflow_instance_id"`
+	IncidentID         interface{}     `json:"incident_id"`
+	IncidentName       interface{}     `json:"incident_name"`
+	Update             json.RawMessage `json:"update"`
+}
+
+// notifier posts workflow status updates to a Dispatch API endpoint or a generic webhook
+type notifier struct {
+	url     string
+	token   string
+	version versions.Adapter
+	client  *WorkflowClient
+	http    *http.Client
+}
+
+// NotifyActivity posts the state of a workflow run to the callback URL. The workflow type is the name the
+// workflow function is registered with in Temporal.
+func (n *notifier) NotifyActivity(ctx context.Context, workflowType string, params map[string]interface{}, update *schema.WorkflowInstanceUpdate) error {
+	if n.url == "" {
+		return nil
+	}
+
+	info := activity.GetInfo(ctx)
+	msg := notification{
+		WorkflowInstanceID: params["instance_id"],
+		IncidentID:         params["incident_id"],
+		IncidentName:       params["incident_name"],
+	}
+	if def, ok := lookupByType(workflowType); ok && !def.Internal {
+		msg.WorkflowID = def.Name
+		// the links are added to a copy, the update of the run is left as it is
+		update = linkableCopy(update)
+		n.client.addLinks(def, update, info.WorkflowExecution.ID, info.WorkflowExecution.RunID)
+	}
+
+	var err error
+	if msg.Update, err = n.version.Encode(update); err != nil {
+		return temporal.NewNonRetryableApplicationError("unable to encode update", callbackRejected, err)
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError("unable to encode notification", callbackRejected, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError("unable to create callback request", callbackRejected, err)
+	}
+	req.Header.Set("content-type", "application/json")
+	if n.token != "" {
+		req.Header.Set("authorization", "Bearer "+n.token)
+	}
+
+	resp, err := n.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("callback rejected with status %d", resp.StatusCode), callbackRejected, nil)
+	default:
+		return fmt.Errorf("callback failed with status %d", resp.StatusCode)
+	}
+}
+
+// linkableCopy returns a shallow copy of the update with copies of the artifacts and parameters addLinks changes
+func linkableCopy(update *schema.WorkflowInstanceUpdate) *schema.WorkflowInstanceUpdate {
+	c := *update
+	c.Artifacts = make([]*schema.DocumentCreate, len(update.Artifacts))
+	for i, a := range update.Artifacts {
+		artifact := *a
+		c.Artifacts[i] = &artifact
+	}
+	c.Parameters = make([]map[string]interface{}, len(update.Parameters))
+	for i, p := range update.Parameters {
+		c.Parameters[i] = map[string]interface{}{}
+		for k, v := range p {
+			c.Parameters[i][k] = v
+		}
+	}
+	return &c
+}
+
+// publisher pushes state changes of a workflow run to the callback URL. Callbacks are sent by a coroutine in
+// the background, so a slow endpoint doesn't hold up the steps of the workflow. Changes made while a callback is
+// in flight are coalesced, the coroutine sends the latest state once the callback returns.
+type publisher struct {
+	enabled bool
+	params  map[string]interface{}
+	// latest is the state to send, pending is set when it changed since the last callback started
+	latest  *schema.WorkflowInstanceUpdate
+	pending bool
+	// sending is set while the coroutine runs
+	sending bool
+}
+
+// newPublisher returns a publisher for the workflow run. Whether callbacks are enabled is recorded in the
+// history of the run. Child workflows and runs that started before callbacks existed don't publish.
+func newPublisher(ctx workflow.Context, params map[string]interface{}) *publisher {
+	p := &publisher{params: params}
+	// child workflows report to Dispatch through their parent
+	if workflow.GetInfo(ctx).ParentWorkflowExecution != nil {
+		return p
+	}
+	if !Changed(ctx, "publish-callbacks") {
+		return p
+	}
+	enabled := settingsFrom(ctx).callbacks
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		return enabled
+	}).Get(&p.enabled)
+	if err != nil {
+		workflow.GetLogger(ctx).Warn("Unable to determine if callbacks are enabled", "Error", err)
+	}
+	return p
+}
+
+// publish sends the update to the callback URL without waiting for the callback. A failing callback is logged
+// and doesn't fail the workflow.
+func (p *publisher) publish(ctx workflow.Context, update *schema.WorkflowInstanceUpdate) {
+	if !p.enabled {
+		return
+	}
+	p.latest, p.pending = update, true
+	if p.sending {
+		return
+	}
+	p.sending = true
+	// callbacks are also sent when the workflow is canceled, so Dispatch learns about its final state
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	ctx = workflow.WithActivityOptions(ctx, notifyActivityOptions)
+	workflowType := workflow.GetInfo(ctx).WorkflowType.Name
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		var n *notifier
+		for p.pending {
+			p.pending = false
+			// the update is encoded when the activity is scheduled, so it carries the latest state
+			if err := workflow.ExecuteActivity(ctx, n.NotifyActivity, workflowType, p.params, p.latest).Get(ctx, nil); err != nil {
+				workflow.GetLogger(ctx).Warn("NotifyActivity failed.", "Error", err)
+			}
+		}
+		p.sending = false
+	})
+}
+
+// flush waits until the callbacks of all published changes returned, so the final state of a run is sent before
+// it closes
+func (p *publisher) flush(ctx workflow.Context) {
+	if !p.sending {
+		return
+	}
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	if err := workflow.Await(ctx, func() bool { return !p.sending }); err != nil {
+		workflow.GetLogger(ctx).Warn("Unable to wait for callbacks", "Error", err)
+	}
+}
@@ -0,0 +1,212 @@
+package workflows
+
+import (
+	"encoding/json"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// callbackStub records the callbacks it receives and answers them with the statuses in order, 204 when they
+// are used up
+type callbackStub struct {
+	mu       sync.Mutex
+	statuses []int
+	requests int
+	auth     []string
+	received []notification
+}
+
+func (s *callbackStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.auth = append(s.auth, r.Header.Get("Authorization"))
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	if status < 300 {
+		var msg notification
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			status = http.StatusBadRequest
+		}
+		s.received = append(s.received, msg)
+	}
+	w.WriteHeader(status)
+}
+
+// updates returns the updates of the callbacks that were accepted
+func (s *callbackStub) updates(t *testing.T) []*schema.WorkflowInstanceUpdate {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var updates []*schema.WorkflowInstanceUpdate
+	for _, msg := range s.received {
+		update := &schema.WorkflowInstanceUpdate{}
+		if err := json.Unmarshal(msg.Update, update); err != nil {
+			t.Fatalf("unable to decode update: %v", err)
+		}
+		updates = append(updates, update)
+	}
+	return updates
+}
+
+func newTestNotifier(t *testing.T, url string) *notifier {
+	version, err := versions.Lookup("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &notifier{
+		url:     url,
+		token:   "secret",
+		version: version,
+		client:  &WorkflowClient{webURL: "http://temporal:8088", namespace: "default"},
+		http:    &http.Client{Timeout: time.Second},
+	}
+}
+
+func TestNotifyActivity(t *testing.T) {
+	stub := &callbackStub{}
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(newTestNotifier(t, srv.URL))
+
+	artifact := &schema.DocumentCreate{Name: "dog.jpg", Description: schema.String("Dog 1"), Filters: []*schema.SearchFilterRead{}}
+	update := &schema.WorkflowInstanceUpdate{
+		Artifacts: []*schema.DocumentCreate{artifact},
+		Status:    schema.WorkflowInstanceStatusRunning,
+	}
+	params := map[string]interface{}{"incident_id": 32, "incident_name": "dispatch-default-default-32", "instance_id": 25}
+	var n *notifier
+	if _, err := env.ExecuteActivity(n.NotifyActivity, "RandomDogWorkflow", params, update); err != nil {
+		t.Fatalf("notify failed: %v", err)
+	}
+
+	if stub.requests != 1 || stub.auth[0] != "Bearer secret" {
+		t.Fatalf("got %d requests with authorization %v", stub.requests, stub.auth)
+	}
+	msg := stub.received[0]
+	if msg.WorkflowID != "random_dog" || msg.WorkflowInstanceID != float64(25) || msg.IncidentID != float64(32) || msg.IncidentName != "dispatch-default-default-32" {
+		t.Errorf("notification is %+v", msg)
+	}
+	got := stub.updates(t)[0]
+	if got.Status != schema.WorkflowInstanceStatusRunning || !strings.HasPrefix(schema.StringValue(got.Weblink), "http://temporal:8088/namespaces/default/workflows/") {
+		t.Errorf("update is %+v", got)
+	}
+	if d := schema.StringValue(got.Artifacts[0].Description); d != "Dog 1" {
+		t.Errorf("artifact description is %q", d)
+	}
+	if len(got.Parameters) != 1 || got.Parameters[0]["key"] != "produced_by" || got.Parameters[0]["value"] != schema.StringValue(got.Weblink) {
+		t.Errorf("the provenance of the artifacts is not reported in %v", got.Parameters)
+	}
+}
+
+func TestNotifyActivityDisabled(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestActivityEnvironment()
+	env.RegisterActivity(newTestNotifier(t, ""))
+
+	var n *notifier
+	update := &schema.WorkflowInstanceUpdate{Artifacts: []*schema.DocumentCreate{}, Status: schema.WorkflowInstanceStatusRunning}
+	if _, err := env.ExecuteActivity(n.NotifyActivity, "RandomDogWorkflow", map[string]interface{}{}, update); err != nil {
+		t.Fatalf("notify without callback URL failed: %v", err)
+	}
+}
+
+// notifyTestWorkflow runs for a minute, then adds an artifact and completes
+func notifyTestWorkflow(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+	tracker, err := NewTracker(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusRunning); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	if err := workflow.Sleep(ctx, time.Minute); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	tracker.AddArtifact(ctx, &schema.DocumentCreate{Name: "report.txt", Filters: []*schema.SearchFilterRead{}})
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusCompleted); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	return tracker.Update(), nil
+}
+
+// runNotifyTestWorkflow runs notifyTestWorkflow with callbacks to the stub and returns its result
+func runNotifyTestWorkflow(t *testing.T, stub *callbackStub, enabled bool) *schema.WorkflowInstanceUpdate {
+	t.Helper()
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterActivity(newTestNotifier(t, srv.URL))
+	// the test environment has no interceptors, the workflow gets the settings of the worker itself
+	env.RegisterWorkflowWithOptions(func(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+		return notifyTestWorkflow(withSettings(ctx, &workerSettings{callbacks: enabled}), params)
+	}, workflow.RegisterOptions{Name: "notifyTestWorkflow"})
+	env.ExecuteWorkflow("notifyTestWorkflow", map[string]interface{}{"instance_id": 25})
+	if !env.IsWorkflowCompleted() || env.GetWorkflowError() != nil {
+		t.Fatalf("workflow failed: %v", env.GetWorkflowError())
+	}
+	var result *schema.WorkflowInstanceUpdate
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestPublishRetries(t *testing.T) {
+	// the first callback fails twice before it is accepted
+	stub := &callbackStub{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	result := runNotifyTestWorkflow(t, stub, true)
+
+	updates := stub.updates(t)
+	if stub.requests != 4 || len(updates) != 2 {
+		t.Fatalf("got %d requests for %d callbacks, want 2 callbacks and 2 retries", stub.requests, len(updates))
+	}
+	if updates[0].Status != schema.WorkflowInstanceStatusRunning {
+		t.Errorf("first callback has status %s", updates[0].Status)
+	}
+	// the artifact and the completion happened while no callback was in flight, they are sent together
+	if updates[1].Status != schema.WorkflowInstanceStatusCompleted || len(updates[1].Artifacts) != 1 {
+		t.Errorf("last callback is %+v, want the completed state", updates[1])
+	}
+	// the sleep started right away, the workflow didn't wait for the retries of the callback
+	if d := result.UpdatedAt.Sub(result.CreatedAt); d != time.Minute {
+		t.Errorf("workflow completed after %s, want 1m0s", d)
+	}
+}
+
+func TestPublishRejected(t *testing.T) {
+	stub := &callbackStub{statuses: []int{http.StatusUnauthorized}}
+	runNotifyTestWorkflow(t, stub, true)
+
+	// a rejected callback isn't retried, the next change is sent
+	updates := stub.updates(t)
+	if stub.requests != 2 || len(updates) != 1 {
+		t.Fatalf("got %d requests for %d callbacks, want 1 rejected request", stub.requests, len(updates))
+	}
+	if updates[0].Status != schema.WorkflowInstanceStatusCompleted {
+		t.Errorf("callback has status %s", updates[0].Status)
+	}
+}
+
+func TestPublishDisabled(t *testing.T) {
+	stub := &callbackStub{}
+	runNotifyTestWorkflow(t, stub, false)
+	if stub.requests != 0 {
+		t.Errorf("got %d callbacks with callbacks disabled", stub.requests)
+	}
+}
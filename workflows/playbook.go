@@ -0,0 +1,219 @@
+package workflows
+
+import (
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"strings"
+	"time"
+)
+
+const (
+	// PlaybookParallel runs the workflows of a playbook at the same time
+	PlaybookParallel = "parallel"
+	// PlaybookSequential runs the workflows of a playbook one after the other, in the order they are listed
+	PlaybookSequential = "sequential"
+	// PlaybookFailFast stops a playbook at the first workflow that fails and cancels the workflows still running
+	PlaybookFailFast = "fail_fast"
+	// PlaybookBestEffort runs all workflows of a playbook, it only fails when all of them fail
+	PlaybookBestEffort = "best_effort"
+
+	// childCanceled is the status reported for a workflow of a playbook that was canceled or never started
+	childCanceled = "Canceled"
+)
+
+func init() {
+	Register(Definition{
+		Name:     "playbook",
+		Workflow: PlaybookWorkflow,
+	})
+}
+
+// Playbook configures the workflows a playbook runs. Dispatch passes it as the workflows, mode and on_failure
+// keys of the workflow configuration.
+type Playbook struct {
+	// Workflows are the names of the registered workflows to run
+	Workflows []string
+	// Mode is PlaybookParallel or PlaybookSequential
+	Mode string
+	// OnFailure is PlaybookFailFast or PlaybookBestEffort
+	OnFailure string
+}
+
+// PlaybookFrom reads the playbook from the workflows, mode and on_failure parameters. Workflows is a comma
+// separated list of workflow names.
+func PlaybookFrom(params map[string]interface{}) (*Playbook, error) {
+	p := &Playbook{Mode: PlaybookParallel, OnFailure: PlaybookFailFast}
+	switch v := params["workflows"].(type) {
+	case string:
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				p.Workflows = append(p.Workflows, name)
+			}
+		}
+	case []interface{}:
+		for _, name := range v {
+			p.Workflows = append(p.Workflows, fmt.Sprint(name))
+		}
+	}
+	if v, ok := params["mode"].(string); ok && v != "" {
+		p.Mode = v
+	}
+	if v, ok := params["on_failure"].(string); ok && v != "" {
+		p.OnFailure = v
+	}
+
+	if len(p.Workflows) == 0 {
+		return nil, fmt.Errorf("a playbook needs the workflows to run")
+	}
+	if p.Mode != PlaybookParallel && p.Mode != PlaybookSequential {
+		return nil, fmt.Errorf("unknown playbook mode %q", p.Mode)
+	}
+	if p.OnFailure != PlaybookFailFast && p.OnFailure != PlaybookBestEffort {
+		return nil, fmt.Errorf("unknown playbook failure mode %q", p.OnFailure)
+	}
+	seen := map[string]bool{}
+	for _, name := range p.Workflows {
+		if _, ok := lookup(name); !ok || name == "playbook" {
+			return nil, fmt.Errorf("workflow %q can't be run by a playbook", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("workflow %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	return p, nil
+}
+
+// childParams returns the params for the workflows of a playbook, without the configuration of the playbook
+func (p *Playbook) childParams(params map[string]interface{}) map[string]interface{} {
+	child := map[string]interface{}{}
+	for k, v := range params {
+		switch k {
+		case "workflows", "mode", "on_failure":
+		default:
+			child[k] = v
+		}
+	}
+	return child
+}
+
+// PlaybookWorkflow runs registered workflows as child workflows and reports them to Dispatch as one. The artifacts
+// of the workflows are collected with the name of the workflow in their description, and the status of every
+// workflow is reported as parameter.
+func PlaybookWorkflow(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+	logger := workflow.GetLogger(ctx)
+
+	tracker, err := NewTracker(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	playbook, err := PlaybookFrom(params)
+	if err != nil {
+		return nil, tracker.Fail(ctx, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidPlaybook", err))
+	}
+	for _, name := range playbook.Workflows {
+		tracker.setParameter(name, string(schema.WorkflowInstanceStatusCreated))
+	}
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusRunning); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+
+	childParams := playbook.childParams(params)
+	childCtx, cancel := workflow.WithCancel(ctx)
+	defer cancel()
+
+	var failed []string
+	// done records the result of a workflow, it returns false when the playbook has to stop
+	done := func(name string, result *schema.WorkflowInstanceUpdate, err error) bool {
+		if err != nil {
+			logger.Warn("Playbook workflow failed", "workflow", name, "Error", err)
+			failed = append(failed, fmt.Sprintf("%s: %s", name, err))
+			tracker.setParameter(name, string(schema.WorkflowInstanceStatusFailed))
+		} else {
+			tracker.setParameter(name, string(schema.WorkflowInstanceStatusCompleted))
+		}
+		if result != nil {
+			for _, a := range result.Artifacts {
+				a.Description = schema.String(fmt.Sprintf("%s: %s", name, schema.StringValue(a.Description)))
+				tracker.update.Artifacts = append(tracker.update.Artifacts, a)
+			}
+		}
+		tracker.changed(ctx)
+		return err == nil || playbook.OnFailure == PlaybookBestEffort
+	}
+
+	if playbook.Mode == PlaybookSequential {
+		for _, name := range playbook.Workflows {
+			tracker.setParameter(name, string(schema.WorkflowInstanceStatusRunning))
+			tracker.changed(ctx)
+			var result *schema.WorkflowInstanceUpdate
+			def, _ := lookup(name)
+			err := executeChild(childCtx, def, name, childParams).Get(ctx, &result)
+			if !done(name, result, err) {
+				break
+			}
+		}
+	} else {
+		selector := workflow.NewSelector(ctx)
+		stopped := false
+		for _, name := range playbook.Workflows {
+			name := name
+			tracker.setParameter(name, string(schema.WorkflowInstanceStatusRunning))
+			def, _ := lookup(name)
+			selector.AddFuture(executeChild(childCtx, def, name, childParams), func(f workflow.Future) {
+				var result *schema.WorkflowInstanceUpdate
+				err := f.Get(ctx, &result)
+				if stopped && err != nil {
+					// canceled after another workflow failed
+					tracker.setParameter(name, childCanceled)
+					tracker.changed(ctx)
+					return
+				}
+				if !done(name, result, err) && !stopped {
+					stopped = true
+					cancel()
+				}
+			})
+		}
+		tracker.changed(ctx)
+		for range playbook.Workflows {
+			selector.Select(ctx)
+		}
+	}
+
+	// workflows that didn't start because the playbook stopped
+	for _, p := range tracker.update.Parameters {
+		if p["value"] == string(schema.WorkflowInstanceStatusCreated) {
+			p["value"] = childCanceled
+		}
+	}
+
+	switch {
+	case len(failed) == 0:
+		tracker.update.RunReason = schema.String(fmt.Sprintf("%d workflows completed", len(playbook.Workflows)))
+	case playbook.OnFailure == PlaybookBestEffort && len(failed) < len(playbook.Workflows):
+		tracker.update.RunReason = schema.String(fmt.Sprintf("%d of %d workflows failed: %s",
+			len(failed), len(playbook.Workflows), strings.Join(failed, "; ")))
+	default:
+		err := temporal.NewApplicationError(strings.Join(failed, "; "), "PlaybookFailed")
+		return nil, tracker.Fail(ctx, err)
+	}
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusCompleted); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	return tracker.Update(), nil
+}
+
+// executeChild starts the workflow of def as child workflow with the timeouts of its policy. The child gets the
+// id of the parent with the suffix.
+func executeChild(ctx workflow.Context, def *Definition, suffix string, params map[string]interface{}) workflow.ChildWorkflowFuture {
+	policy := policyFor(def, settingsFrom(ctx).policies)
+	ctx = workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID:               workflow.GetInfo(ctx).WorkflowExecution.ID + "-" + suffix,
+		WorkflowExecutionTimeout: time.Duration(policy.ExecutionTimeout),
+		WorkflowRunTimeout:       time.Duration(policy.RunTimeout),
+	})
+	return workflow.ExecuteChildWorkflow(ctx, def.Workflow, params)
+}
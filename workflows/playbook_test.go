@@ -0,0 +1,118 @@
+package workflows_test
+
+import (
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+// playbookEnv returns a test environment where the dog workflow returns an artifact and the Unsplash workflow
+// returns unsplashErr
+func playbookEnv(t *testing.T, unsplashErr error) *workflowtest.Env {
+	env := workflowtest.New(t)
+	env.RegisterWorkflow(workflows.RandomDogWorkflow)
+	env.RegisterWorkflow(workflows.RandomUnsplashWorkflow)
+
+	dog := workflowtest.Artifact("dog.jpg")
+	dog.Description = schema.String("Dog 1")
+	env.OnWorkflow(workflows.RandomDogWorkflow, mock.Anything, mock.Anything).Return(&schema.WorkflowInstanceUpdate{
+		Status:    schema.WorkflowInstanceStatusCompleted,
+		Artifacts: []*schema.DocumentCreate{dog},
+	}, nil)
+	if unsplashErr != nil {
+		env.OnWorkflow(workflows.RandomUnsplashWorkflow, mock.Anything, mock.Anything).Return(nil, unsplashErr)
+	} else {
+		env.OnWorkflow(workflows.RandomUnsplashWorkflow, mock.Anything, mock.Anything).Return(&schema.WorkflowInstanceUpdate{
+			Status:    schema.WorkflowInstanceStatusCompleted,
+			Artifacts: []*schema.DocumentCreate{workflowtest.Artifact("photo.jpg")},
+		}, nil)
+	}
+	return env
+}
+
+// assertChildren fails the test when the status parameters of the workflows differ from want
+func assertChildren(t *testing.T, state *schema.WorkflowInstanceUpdate, want map[string]string) {
+	t.Helper()
+	got := map[string]interface{}{}
+	for _, p := range state.Parameters {
+		got[p["key"].(string)] = p["value"]
+	}
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("status of %s is %v, want %s", name, got[name], status)
+		}
+	}
+}
+
+func TestPlaybookWorkflow(t *testing.T) {
+	env := playbookEnv(t, nil)
+	result := env.Run(workflows.PlaybookWorkflow, workflowtest.Params(map[string]interface{}{
+		"workflows": "random_dog, random_unsplash",
+		"term":      "cats",
+	}))
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	if len(result.Artifacts) != 2 {
+		t.Fatalf("got %d artifacts, want 2", len(result.Artifacts))
+	}
+	for _, a := range result.Artifacts {
+		if a.Name == "dog.jpg" && schema.StringValue(a.Description) != "random_dog: Dog 1" {
+			t.Errorf("description of the dog is %q", schema.StringValue(a.Description))
+		}
+	}
+	assertChildren(t, result, map[string]string{"random_dog": "Completed", "random_unsplash": "Completed"})
+}
+
+func TestPlaybookWorkflowFailFast(t *testing.T) {
+	env := playbookEnv(t, errors.New("unsplash is down"))
+	state, err := env.RunError(workflows.PlaybookWorkflow, workflowtest.Params(map[string]interface{}{
+		"workflows": "random_unsplash,random_dog",
+		"mode":      workflows.PlaybookSequential,
+	}))
+	if err == nil {
+		t.Fatal("expected the playbook to fail")
+	}
+	workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusFailed)
+	workflowtest.AssertArtifacts(t, state)
+	assertChildren(t, state, map[string]string{"random_unsplash": "Failed", "random_dog": "Canceled"})
+}
+
+func TestPlaybookWorkflowBestEffort(t *testing.T) {
+	env := playbookEnv(t, errors.New("unsplash is down"))
+	result := env.Run(workflows.PlaybookWorkflow, workflowtest.Params(map[string]interface{}{
+		"workflows":  "random_unsplash,random_dog",
+		"on_failure": workflows.PlaybookBestEffort,
+	}))
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	workflowtest.AssertArtifacts(t, result, "dog.jpg")
+	assertChildren(t, result, map[string]string{"random_unsplash": "Failed", "random_dog": "Completed"})
+	if result.RunReason == nil {
+		t.Error("run_reason doesn't mention the failed workflow")
+	}
+}
+
+func TestPlaybookFrom(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{},
+		{"workflows": "random_dog", "mode": "sometimes"},
+		{"workflows": "random_dog", "on_failure": "panic"},
+		{"workflows": "random_cat"},
+		{"workflows": "playbook"},
+		{"workflows": "random_dog,random_dog"},
+	}
+	for _, params := range invalid {
+		if _, err := workflows.PlaybookFrom(params); err == nil {
+			t.Errorf("PlaybookFrom(%v) accepted an invalid playbook", params)
+		}
+	}
+
+	p, err := workflows.PlaybookFrom(map[string]interface{}{"workflows": []interface{}{"random_dog", "random_unsplash"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Workflows) != 2 || p.Mode != workflows.PlaybookParallel || p.OnFailure != workflows.PlaybookFailFast {
+		t.Errorf("unexpected playbook %+v", p)
+	}
+}
@@ -0,0 +1,173 @@
+package workflows
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"os"
+	"time"
+)
+
+// defaultStartToCloseTimeout is used for activities without a configured timeout, Temporal requires one
+const defaultStartToCloseTimeout = 10 * time.Second
+
+// Duration is a time.Duration that is written as a string like "1m30s" in JSON
+type Duration time.Duration
+
+// MarshalJSON writes the duration as string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration string like "1m30s"
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RetryPolicy configures the retries of an activity, zero values use the defaults of Temporal
+type RetryPolicy struct {
+	InitialInterval        Duration `json:"initial_interval,omitempty"`
+	BackoffCoefficient     float64  `json:"backoff_coefficient,omitempty"`
+	MaximumInterval        Duration `json:"maximum_interval,omitempty"`
+	MaximumAttempts        int32    `json:"maximum_attempts,omitempty"`
+	NonRetryableErrorTypes []string `json:"non_retryable_error_types,omitempty"`
+}
+
+// ActivityPolicy configures the timeouts and retries of an activity
+type ActivityPolicy struct {
+	ScheduleToCloseTimeout Duration     `json:"schedule_to_close_timeout,omitempty"`
+	StartToCloseTimeout    Duration     `json:"start_to_close_timeout,omitempty"`
+	HeartbeatTimeout       Duration     `json:"heartbeat_timeout,omitempty"`
+	Retry                  *RetryPolicy `json:"retry,omitempty"`
+}
+
+// Policy configures the timeouts of a workflow and the timeouts and retries of its activities
+type Policy struct {
+	// ExecutionTimeout limits the total time of the workflow, including retries and continue as new
+	ExecutionTimeout Duration `json:"execution_timeout,omitempty"`
+	// RunTimeout limits the time of a single run
+	RunTimeout Duration `json:"run_timeout,omitempty"`
+	// Activity applies to all activities of the workflow
+	Activity ActivityPolicy `json:"activity,omitempty"`
+	// Activities override the policy of single activities by activity name
+	Activities map[string]ActivityPolicy `json:"activities,omitempty"`
+	// Concurrency limits the runs of the workflow that are open at the same time
+	Concurrency Concurrency `json:"concurrency,omitempty"`
+}
+
+// Config is the configuration file of td
+type Config struct {
+	// HTTPTimeout is the timeout of the outbound HTTP calls of activities
+	HTTPTimeout Duration `json:"http_timeout,omitempty"`
+	// MaxConcurrentRuns limits the open runs of all workflows together
+	MaxConcurrentRuns int `json:"max_concurrent_runs,omitempty"`
+	// Workflows override the policies of the registered workflows by name
+	Workflows map[string]Policy `json:"workflows,omitempty"`
+}
+
+// LoadConfig reads the JSON configuration file at path. Unknown fields and workflows are an error, to catch
+// typos before a workflow runs with the wrong timeouts.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := &Config{}
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	for name := range config.Workflows {
+		if _, ok := lookup(name); !ok {
+			return nil, fmt.Errorf("invalid config %s: workflow %q is not registered", path, name)
+		}
+	}
+	return config, nil
+}
+
+// policyFor returns the policy of the definition with the configured policy of its name applied
+func policyFor(def *Definition, policies map[string]Policy) Policy {
+	return def.Policy.merge(policies[def.Name])
+}
+
+// ActivityOptions returns the options for the activity of the running workflow, from the policy of its
+// definition and the configuration of the worker
+func ActivityOptions(ctx workflow.Context, activity string) workflow.ActivityOptions {
+	var p Policy
+	if def, ok := lookupByType(workflow.GetInfo(ctx).WorkflowType.Name); ok {
+		p = policyFor(def, settingsFrom(ctx).policies)
+	}
+	ap := p.Activity.merge(p.Activities[activity])
+
+	options := workflow.ActivityOptions{
+		ScheduleToCloseTimeout: time.Duration(ap.ScheduleToCloseTimeout),
+		StartToCloseTimeout:    time.Duration(ap.StartToCloseTimeout),
+		HeartbeatTimeout:       time.Duration(ap.HeartbeatTimeout),
+	}
+	if options.StartToCloseTimeout == 0 && options.ScheduleToCloseTimeout == 0 {
+		options.StartToCloseTimeout = defaultStartToCloseTimeout
+	}
+	if r := ap.Retry; r != nil {
+		options.RetryPolicy = &temporal.RetryPolicy{
+			InitialInterval:        time.Duration(r.InitialInterval),
+			BackoffCoefficient:     r.BackoffCoefficient,
+			MaximumInterval:        time.Duration(r.MaximumInterval),
+			MaximumAttempts:        r.MaximumAttempts,
+			NonRetryableErrorTypes: r.NonRetryableErrorTypes,
+		}
+	}
+	return options
+}
+
+// merge returns the policy with the values that are set in o applied
+func (p Policy) merge(o Policy) Policy {
+	if o.ExecutionTimeout != 0 {
+		p.ExecutionTimeout = o.ExecutionTimeout
+	}
+	if o.RunTimeout != 0 {
+		p.RunTimeout = o.RunTimeout
+	}
+	p.Activity = p.Activity.merge(o.Activity)
+
+	activities := map[string]ActivityPolicy{}
+	for name, ap := range p.Activities {
+		activities[name] = ap
+	}
+	for name, ap := range o.Activities {
+		activities[name] = activities[name].merge(ap)
+	}
+	p.Activities = activities
+	p.Concurrency = p.Concurrency.merge(o.Concurrency)
+	return p
+}
+
+// merge returns the activity policy with the values that are set in o applied. A retry policy replaces the
+// retry policy as a whole.
+func (p ActivityPolicy) merge(o ActivityPolicy) ActivityPolicy {
+	if o.ScheduleToCloseTimeout != 0 {
+		p.ScheduleToCloseTimeout = o.ScheduleToCloseTimeout
+	}
+	if o.StartToCloseTimeout != 0 {
+		p.StartToCloseTimeout = o.StartToCloseTimeout
+	}
+	if o.HeartbeatTimeout != 0 {
+		p.HeartbeatTimeout = o.HeartbeatTimeout
+	}
+	if o.Retry != nil {
+		p.Retry = o.Retry
+	}
+	return p
+}
@@ -0,0 +1,126 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+		"http_timeout": "3s",
+		"workflows": {
+			"random_dog": {
+				"run_timeout": "5m",
+				"activity": {"retry": {"maximum_attempts": 3}},
+				"activities": {
+					"FetchRandomDogActivity": {"heartbeat_timeout": "2s", "retry": {"non_retryable_error_types": ["NotFound"]}}
+				}
+			}
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(config.HTTPTimeout) != 3*time.Second {
+		t.Errorf("http timeout is %s", time.Duration(config.HTTPTimeout))
+	}
+
+	def, _ := lookup("random_dog")
+	p := def.Policy.merge(config.Workflows["random_dog"])
+	if time.Duration(p.RunTimeout) != 5*time.Minute {
+		t.Errorf("run timeout is %s", time.Duration(p.RunTimeout))
+	}
+	// the start to close timeout of the definition is kept
+	if time.Duration(p.Activity.StartToCloseTimeout) != 10*time.Second || p.Activity.Retry.MaximumAttempts != 3 {
+		t.Errorf("unexpected activity policy %+v", p.Activity)
+	}
+	ap := p.Activity.merge(p.Activities["FetchRandomDogActivity"])
+	if time.Duration(ap.HeartbeatTimeout) != 2*time.Second || ap.Retry.MaximumAttempts != 0 || ap.Retry.NonRetryableErrorTypes[0] != "NotFound" {
+		t.Errorf("unexpected policy of FetchRandomDogActivity %+v %+v", ap, ap.Retry)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for name, config := range map[string]string{
+		"unknown field":    `{"workflows": {"random_dog": {"timeout": "1s"}}}`,
+		"unknown workflow": `{"workflows": {"random_cat": {}}}`,
+		"invalid duration": `{"http_timeout": "soon"}`,
+		"number duration":  `{"http_timeout": 10}`,
+	} {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestConfiguredActivityOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+		"workflows": {
+			"random_dog": {
+				"activity": {"retry": {"initial_interval": "1s", "maximum_attempts": 3}},
+				"activities": {"FetchRandomDogActivity": {"start_to_close_timeout": "20s", "heartbeat_timeout": "2s"}}
+			}
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterActivity(&DogActivities{})
+	// the test environment has no interceptors, the workflow gets the settings of the worker itself
+	env.RegisterWorkflowWithOptions(func(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+		return RandomDogWorkflow(withSettings(ctx, &workerSettings{policies: config.Workflows}), params)
+	}, workflow.RegisterOptions{Name: functionName(RandomDogWorkflow)})
+
+	var attempts []activity.Info
+	var a *DogActivities
+	env.OnActivity(a.FetchRandomDogActivity, mock.Anything, mock.Anything).
+		Return(func(ctx context.Context, params map[string]interface{}) (*schema.DocumentCreate, error) {
+			attempts = append(attempts, activity.GetInfo(ctx))
+			return nil, errors.New("dog.ceo is down")
+		})
+	env.ExecuteWorkflow(functionName(RandomDogWorkflow), map[string]interface{}{"instance_id": 25})
+	if !env.IsWorkflowCompleted() || env.GetWorkflowError() == nil {
+		t.Fatal("expected the workflow to fail")
+	}
+
+	// the retry policy of the workflow applies with the timeouts configured for the activity
+	if len(attempts) != 3 {
+		t.Fatalf("activity ran %d times, want 3", len(attempts))
+	}
+	for _, info := range attempts {
+		if info.HeartbeatTimeout != 2*time.Second {
+			t.Errorf("attempt %d has heartbeat timeout %s", info.Attempt, info.HeartbeatTimeout)
+		}
+		if d := info.Deadline.Sub(info.StartedTime); d != 20*time.Second {
+			t.Errorf("attempt %d has start to close timeout %s", info.Attempt, d)
+		}
+	}
+}
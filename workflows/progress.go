@@ -0,0 +1,126 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"go.temporal.io/sdk/workflow"
+	"log"
+	"time"
+)
+
+// Step is a step of a workflow run
+type Step struct {
+	Name     string     `json:"name"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Progress is the result of the "progress" query. Percent is the share of the steps that finished.
+type Progress struct {
+	Percent int     `json:"percent"`
+	Steps   []*Step `json:"steps"`
+}
+
+// Plan sets the steps the run is going to take, so the progress can be reported before they start
+func (t *Tracker) Plan(names ...string) {
+	for _, name := range names {
+		t.progress.Steps = append(t.progress.Steps, &Step{Name: name})
+	}
+}
+
+// Step finishes the current step and starts the step name, which is added when it wasn't planned. The step is
+// reported as run reason, like "Step 2 of 4: Fetch the first dog (25%)". Steps aren't published on their own,
+// they are sent with the next change of the run.
+func (t *Tracker) Step(ctx workflow.Context, name string) {
+	t.finishStep(ctx, nil)
+	now := workflow.Now(ctx).UTC()
+	step := t.step(name)
+	if step == nil {
+		step = &Step{Name: name}
+		t.progress.Steps = append(t.progress.Steps, step)
+	}
+	step.Started = &now
+	t.current = step
+
+	t.updatePercent()
+	t.update.RunReason = schema.String(fmt.Sprintf("Step %d of %d: %s (%d%%)",
+		t.stepNumber(step), len(t.progress.Steps), name, t.progress.Percent))
+	t.update.UpdatedAt = now
+}
+
+// finishStep finishes the current step with err
+func (t *Tracker) finishStep(ctx workflow.Context, err error) {
+	if t.current == nil {
+		return
+	}
+	now := workflow.Now(ctx).UTC()
+	t.current.Finished = &now
+	if err != nil {
+		t.current.Error = err.Error()
+	}
+	t.current = nil
+	t.updatePercent()
+}
+
+func (t *Tracker) step(name string) *Step {
+	for _, s := range t.progress.Steps {
+		if s.Name == name && s.Started == nil {
+			return s
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) stepNumber(step *Step) int {
+	for i, s := range t.progress.Steps {
+		if s == step {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (t *Tracker) updatePercent() {
+	if len(t.progress.Steps) == 0 {
+		return
+	}
+	finished := 0
+	for _, s := range t.progress.Steps {
+		if s.Finished != nil && s.Error == "" {
+			finished++
+		}
+	}
+	t.progress.Percent = finished * 100 / len(t.progress.Steps)
+}
+
+// Progress returns the steps of a workflow run. Queued starts, runs of workflows without steps and runs that
+// can't be queried anymore report no steps. ErrWorkflowNotFound is returned when Temporal doesn't know the workflow.
+func (ws *WorkflowClient) Progress(workflowID, instanceID string) (*Progress, error) {
+	if instanceID != "" {
+		workflowID += "-" + instanceID
+	}
+	if _, queued := ws.queuedState(workflowID); queued {
+		return &Progress{Steps: []*Step{}}, nil
+	}
+	c, err := ws.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if _, err := c.DescribeWorkflowExecution(ctx, workflowID, ""); err != nil {
+		return nil, notFoundErr(err)
+	}
+	progress := &Progress{Steps: []*Step{}}
+	resp, err := c.QueryWorkflow(ctx, workflowID, "", "progress")
+	if err != nil {
+		log.Println("Unable to query progress of workflow", workflowID, err)
+		return progress, nil
+	}
+	if err := resp.Get(progress); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
@@ -14,10 +14,23 @@ import (
 )
 
 func init() {
-	if availableWorkflows == nil {
-		availableWorkflows = make(map[string]interface{})
-	}
-	availableWorkflows["random_dog"] = RandomDogWorkflow
+	activities := &DogActivities{}
+	Register(Definition{
+		Name:       "random_dog",
+		Workflow:   RandomDogWorkflow,
+		Activities: []interface{}{activities},
+		Configure: func(env Environment) {
+			activities.BaseURL = env.baseURL("dog", activities.BaseURL)
+			activities.HTTPClient = env.HTTPClient
+			activities.Now = env.Now
+			activities.Mirror = env.Mirror
+		},
+		Policy: Policy{
+			Activity: ActivityPolicy{StartToCloseTimeout: Duration(10 * time.Second)},
+		},
+		// 2: reports its steps, publishes callbacks and fetches with the activities of the environment
+		Version: "2",
+	})
 }
 
 // RandomDogWorkflow is a Hello World workflow definition.
@@ -31,80 +44,96 @@ func RandomDogWorkflow(ctx workflow.Context, params map[string]interface{}) (*sc
 	          "instance_id": 25
 	        }
 	*/
-	result := &schema.WorkflowInstanceUpdate{
-		CreatedAt:    workflow.Now(ctx).UTC().Format(format),
-		Artifacts:    []*schema.DocumentCreate{},
-		Parameters:   nil,
-		ResourceId:   "",
-		ResourceType: "",
-		RunReason:    "",
-		Status:       "Failed",
-		UpdatedAt:    workflow.Now(ctx).UTC().Format(format),
-		Weblink:      "https://dog.ceo/",
-	}
-
-	ao := workflow.ActivityOptions{
-		StartToCloseTimeout: 10 * time.Second,
-	}
-	ctx = workflow.WithActivityOptions(ctx, ao)
+	ctx = workflow.WithActivityOptions(ctx, ActivityOptions(ctx, "FetchRandomDogActivity"))
 
 	logger := workflow.GetLogger(ctx)
 	logger.Info("workflow started", "name", params["workflow_instance_id"])
 
-	// setup query handler for query type "state"
-	err := workflow.SetQueryHandler(ctx, "state", func() (*schema.WorkflowInstanceUpdate, error) {
-		return result, nil
-	})
+	// the tracker serves the state to the query handler for query type "state"
+	tracker, err := NewTracker(ctx, params)
 	if err != nil {
-		logger.Info("SetQueryHandler failed: " + err.Error())
+		logger.Info("NewTracker failed: " + err.Error())
 		return nil, err
 	}
 
+	tracker.Plan("Wait for the first dog", "Fetch the first dog", "Wait for the second dog", "Fetch the second dog")
+
 	// we have setup everything, now we go into a running state
-	result.Status = "Running"
-	result.UpdatedAt = workflow.Now(ctx).UTC().Format(format)
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusRunning); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
 
+	tracker.Step(ctx, "Wait for the first dog")
 	// to simulate workflow been blocked on something, in reality, workflow could wait on anything like activity, signal or timer
 	_ = workflow.NewTimer(ctx, time.Second*15).Get(ctx, nil)
 	logger.Info("Timer fired")
 
+	var a *DogActivities
 	var artifact1 *schema.DocumentCreate
-	err = workflow.ExecuteActivity(ctx, FetchRandomDogActivity, params).Get(ctx, &artifact1)
+	tracker.Step(ctx, "Fetch the first dog")
+	err = workflow.ExecuteActivity(ctx, a.FetchRandomDogActivity, params).Get(ctx, &artifact1)
 	if err != nil {
-		result.Status = "Failed"
 		logger.Error("FetchRandomDogActivity failed.", "Error", err)
-		return nil, err
+		return nil, tracker.Fail(ctx, err)
 	}
-	artifact1.Description = "Dog 1"
-	result.Artifacts = append(result.Artifacts, artifact1)
-	result.UpdatedAt = workflow.Now(ctx).UTC().Format(format)
+	setLabel(artifact1, "Dog 1")
+	tracker.AddArtifact(ctx, artifact1)
 
+	tracker.Step(ctx, "Wait for the second dog")
 	// to simulate workflow been blocked on something, in reality, workflow could wait on anything like activity, signal or timer
 	_ = workflow.NewTimer(ctx, time.Second*15).Get(ctx, nil)
 
 	var artifact2 *schema.DocumentCreate
-	err = workflow.ExecuteActivity(ctx, FetchRandomDogActivity, params).Get(ctx, &artifact2)
+	tracker.Step(ctx, "Fetch the second dog")
+	err = workflow.ExecuteActivity(ctx, a.FetchRandomDogActivity, params).Get(ctx, &artifact2)
 	if err != nil {
-		result.Status = "Failed"
 		logger.Error("FetchRandomDogActivity failed.", "Error", err)
-		return nil, err
+		return nil, tracker.Fail(ctx, err)
 	}
-	artifact2.Description = "Dog 2"
-	result.Artifacts = append(result.Artifacts, artifact2)
+	setLabel(artifact2, "Dog 2")
+	tracker.AddArtifact(ctx, artifact2)
 
-	result.UpdatedAt = workflow.Now(ctx).UTC().Format(format)
-	result.Status = "Completed"
-	logger.Info("Random Dog workflow completed.", "result", result)
-	return result, nil
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusCompleted); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	logger.Info("Random Dog workflow completed.", "result", tracker.Update())
+	return tracker.Update(), nil
+}
+
+// DefaultDogAPIURL is the base URL of the dog.ceo API
+const DefaultDogAPIURL = "https://dog.ceo/api"
+
+// DogActivities fetch pictures from the dog.ceo API. The zero value calls the real API.
+type DogActivities struct {
+	// BaseURL of the dog.ceo API. Defaults to DefaultDogAPIURL.
+	BaseURL string
+	// HTTPClient is used for the API calls. Defaults to a client with a 1 second timeout.
+	HTTPClient *http.Client
+	// Now returns the time the artifacts are created at. Defaults to time.Now.
+	Now func() time.Time
+	// Mirror stores a copy of the picture in the artifact store when set, the artifact links to the copy
+	Mirror *Mirror
 }
 
 //FetchRandomDogActivity calls the dog.ceo api for a random dog picture
-func FetchRandomDogActivity(ctx context.Context, params map[string]interface{}) (*schema.DocumentCreate, error) {
+func (a *DogActivities) FetchRandomDogActivity(ctx context.Context, params map[string]interface{}) (*schema.DocumentCreate, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("FetchRandomDogActivity", "name", params["workflow_instance_id"])
 
-	c := http.Client{Timeout: time.Duration(1) * time.Second}
-	resp, err := c.Get("https://dog.ceo/api/breeds/image/random")
+	baseURL := DefaultDogAPIURL
+	if a.BaseURL != "" {
+		baseURL = trimURL(a.BaseURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/breeds/image/random", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := a.HTTPClient
+	if c == nil {
+		c = &http.Client{Timeout: time.Duration(1) * time.Second}
+	}
+	resp, err := c.Do(req)
 	if err != nil {
 		fmt.Printf("Error %s", err)
 		return nil, err
@@ -120,16 +149,20 @@ func FetchRandomDogActivity(ctx context.Context, params map[string]interface{})
 		return nil, errors.New(randomDog.Message)
 	}
 
+	now := currentTime(a.Now)
 	artifact := &schema.DocumentCreate{
-		CreatedAt:    time.Now().UTC().Format(format),
-		Description:  "Random dog",
+		CreatedAt:    schema.Time(now),
+		Description:  schema.String("Random dog"),
 		Evergreen:    false,
 		Name:         path.Base(randomDog.Message),
-		ResourceId:   path.Base(randomDog.Message),
-		ResourceType: "",
+		ResourceId:   schema.String(path.Base(randomDog.Message)),
+		ResourceType: nil,
 		Filters:      []*schema.SearchFilterRead{},
-		UpdatedAt:    time.Now().UTC().Format(format),
-		Weblink:      randomDog.Message,
+		UpdatedAt:    schema.Time(now),
+		Weblink:      schema.String(randomDog.Message),
+	}
+	if a.Mirror != nil {
+		return a.Mirror.Copy(ctx, c, randomDog.Message, artifact)
 	}
 
 	return artifact, nil
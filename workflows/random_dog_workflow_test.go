@@ -0,0 +1,102 @@
+package workflows_test
+
+import (
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"github.com/stretchr/testify/mock"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomDogWorkflow(t *testing.T) {
+	env := workflowtest.New(t)
+	var activities *workflows.DogActivities
+	env.OnActivity(activities.FetchRandomDogActivity, mock.Anything, mock.Anything).
+		Return(workflowtest.Artifact("n02085620_7.jpg"), nil).Once()
+	env.OnActivity(activities.FetchRandomDogActivity, mock.Anything, mock.Anything).
+		Return(workflowtest.Artifact("n02088094_1003.jpg"), nil).Once()
+
+	env.At(time.Second, func(state *schema.WorkflowInstanceUpdate) {
+		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusRunning)
+		workflowtest.AssertArtifacts(t, state)
+	})
+	env.At(20*time.Second, func(state *schema.WorkflowInstanceUpdate) {
+		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusRunning)
+		workflowtest.AssertArtifacts(t, state, "n02085620_7.jpg")
+		if got := schema.StringValue(state.RunReason); got != "Step 3 of 4: Wait for the second dog (50%)" {
+			t.Errorf("run_reason is %q", got)
+		}
+		progress := env.Progress()
+		if progress.Percent != 50 || len(progress.Steps) != 4 || progress.Steps[2].Started == nil || progress.Steps[2].Finished != nil {
+			t.Errorf("unexpected progress %+v", progress)
+		}
+	})
+
+	result := env.Run(workflows.RandomDogWorkflow, workflowtest.Params(nil))
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	workflowtest.AssertArtifacts(t, result, "n02085620_7.jpg", "n02088094_1003.jpg")
+	if got := schema.StringValue(result.RunReason); got != "Completed 4 steps" {
+		t.Errorf("run_reason is %q", got)
+	}
+	if got := schema.StringValue(result.Artifacts[1].Description); got != "Dog 2" {
+		t.Errorf("description of the second artifact is %q", got)
+	}
+	if len(result.Parameters) != 1 || result.Parameters[0]["key"] != "code_version" || result.Parameters[0]["value"] != "2" {
+		t.Errorf("code version is not reported in %v", result.Parameters)
+	}
+	if !result.UpdatedAt.After(result.CreatedAt) {
+		t.Errorf("updated_at %s is not after created_at %s", result.UpdatedAt, result.CreatedAt)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestRandomDogWorkflowFailed(t *testing.T) {
+	env := workflowtest.New(t)
+	var activities *workflows.DogActivities
+	env.OnActivity(activities.FetchRandomDogActivity, mock.Anything, mock.Anything).
+		Return(nil, errors.New("dog.ceo is down"))
+
+	state, err := env.RunError(workflows.RandomDogWorkflow, workflowtest.Params(nil))
+	if err == nil {
+		t.Fatal("expected the workflow to fail")
+	}
+	workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusFailed)
+	workflowtest.AssertArtifacts(t, state)
+	if state.RunReason == nil {
+		t.Error("run_reason is not set")
+	}
+	if step := env.Progress().Steps[1]; step.Name != "Fetch the first dog" || !strings.Contains(step.Error, "dog.ceo is down") {
+		t.Errorf("the failed step is not reported: %+v", step)
+	}
+}
+
+func TestRandomDogWorkflowOffline(t *testing.T) {
+	fake := workflowtest.NewDogAPI()
+	defer fake.Close()
+	created := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	env := workflowtest.New(t)
+	env.RegisterActivity(&workflows.DogActivities{
+		BaseURL:    fake.BaseURL(),
+		HTTPClient: fake.Client(),
+		Now:        func() time.Time { return created },
+	})
+
+	result := env.Run(workflows.RandomDogWorkflow, workflowtest.Params(nil))
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	workflowtest.AssertArtifacts(t, result, "n02085620_1.jpg", "n02085620_2.jpg")
+	for _, a := range result.Artifacts {
+		if !strings.HasPrefix(schema.StringValue(a.Weblink), fake.URL) {
+			t.Errorf("weblink %s doesn't point to the fake", schema.StringValue(a.Weblink))
+		}
+		if !a.CreatedAt.Equal(created) {
+			t.Errorf("artifact created at %s, want %s", a.CreatedAt, created)
+		}
+	}
+	if fake.Requests() != 2 {
+		t.Errorf("got %d requests, want 2", fake.Requests())
+	}
+}
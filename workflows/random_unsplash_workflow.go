@@ -13,10 +13,23 @@ import (
 )
 
 func init() {
-	if availableWorkflows == nil {
-		availableWorkflows = make(map[string]interface{})
-	}
-	availableWorkflows["random_unsplash"] = RandomUnsplashWorkflow
+	activities := &UnsplashActivities{}
+	Register(Definition{
+		Name:       "random_unsplash",
+		Workflow:   RandomUnsplashWorkflow,
+		Activities: []interface{}{activities, initActivity},
+		Configure: func(env Environment) {
+			activities.BaseURL = env.baseURL("unsplash", activities.BaseURL)
+			activities.HTTPClient = env.HTTPClient
+			activities.Now = env.Now
+			activities.Mirror = env.Mirror
+		},
+		Policy: Policy{
+			Activity: ActivityPolicy{StartToCloseTimeout: Duration(10 * time.Second)},
+		},
+		// 2: reports its steps, publishes callbacks and fetches with the activities of the environment
+		Version: "2",
+	})
 }
 
 // RandomUnsplashWorkflow is a Hello World workflow definition.
@@ -30,89 +43,102 @@ func RandomUnsplashWorkflow(ctx workflow.Context, params map[string]interface{})
 	          "instance_id": 25
 	        }
 	*/
-	result := &schema.WorkflowInstanceUpdate{
-		CreatedAt:    workflow.Now(ctx).UTC().Format(format),
-		Artifacts:    []*schema.DocumentCreate{},
-		Parameters:   nil,
-		ResourceId:   "",
-		ResourceType: "",
-		RunReason:    "",
-		Status:       "Created",
-		UpdatedAt:    time.Now().UTC().Format(format),
-		Weblink:      "https://unsplash.com/",
-	}
-
-	ao := workflow.ActivityOptions{
-		StartToCloseTimeout: 10 * time.Second,
-	}
-	ctx = workflow.WithActivityOptions(ctx, ao)
+	ctx = workflow.WithActivityOptions(ctx, ActivityOptions(ctx, "FetchRandomUnsplashActivity"))
 
 	logger := workflow.GetLogger(ctx)
 	logger.Info("workflow started", "name", params["workflow_instance_id"])
 
-	// setup query handler for query type "state"
-	err := workflow.SetQueryHandler(ctx, "state", func() (*schema.WorkflowInstanceUpdate, error) {
-		return result, nil
-	})
-
+	// the tracker serves the state to the query handler for query type "state"
+	tracker, err := NewTracker(ctx, params)
 	if err != nil {
-		result.Status = "Failed"
-		logger.Info("SetQueryHandler failed: " + err.Error())
-		return result, err
+		logger.Info("NewTracker failed: " + err.Error())
+		return nil, err
 	}
-	err = workflow.ExecuteActivity(ctx, initActivity, params).Get(ctx, &result.Status)
-	if err != nil {
-		result.Status = "Failed"
-		logger.Error("InitActivity failed.", "Error", err)
-		return result, err
+
+	tracker.Plan("Wait for the first photo", "Fetch the first photo", "Wait for the second photo", "Fetch the second photo")
+
+	// we have setup everything, now we go into a running state
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusRunning); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+
+	// runs that started on version 1 moved to Running with an activity
+	if !Changed(ctx, "random_unsplash-running-without-activity") {
+		var status string
+		if err := workflow.ExecuteActivity(ctx, initActivity, params).Get(ctx, &status); err != nil {
+			logger.Error("InitActivity failed.", "Error", err)
+			return nil, tracker.Fail(ctx, err)
+		}
 	}
-	result.UpdatedAt = workflow.Now(ctx).UTC().Format(format)
 
+	tracker.Step(ctx, "Wait for the first photo")
 	// to simulate workflow been blocked on something, in reality, workflow could wait on anything like activity, signal or timer
 	_ = workflow.NewTimer(ctx, time.Second*15).Get(ctx, nil)
+	logger.Info("Timer fired")
 
+	var a *UnsplashActivities
 	var artifact1 *schema.DocumentCreate
-	err = workflow.ExecuteActivity(ctx, FetchRandomUnsplashActivity, params).Get(ctx, &artifact1)
+	tracker.Step(ctx, "Fetch the first photo")
+	err = workflow.ExecuteActivity(ctx, a.FetchRandomUnsplashActivity, params).Get(ctx, &artifact1)
 	if err != nil {
-		result.Status = "Failed"
 		logger.Error("FetchRandomUnsplashActivity failed.", "Error", err)
-		return nil, err
+		return nil, tracker.Fail(ctx, err)
 	}
-	artifact1.Description = "Photo 1"
-	result.Artifacts = append(result.Artifacts, artifact1)
-	result.UpdatedAt = workflow.Now(ctx).UTC().Format(format)
+	setLabel(artifact1, "Photo 1")
+	tracker.AddArtifact(ctx, artifact1)
 
+	tracker.Step(ctx, "Wait for the second photo")
 	// to simulate workflow been blocked on something, in reality, workflow could wait on anything like activity, signal or timer
 	_ = workflow.NewTimer(ctx, time.Second*15).Get(ctx, nil)
 
 	var artifact2 *schema.DocumentCreate
-	err = workflow.ExecuteActivity(ctx, FetchRandomUnsplashActivity, params).Get(ctx, &artifact2)
+	tracker.Step(ctx, "Fetch the second photo")
+	err = workflow.ExecuteActivity(ctx, a.FetchRandomUnsplashActivity, params).Get(ctx, &artifact2)
 	if err != nil {
-		result.Status = "Failed"
 		logger.Error("FetchRandomUnsplashActivity failed.", "Error", err)
-		return nil, err
+		return nil, tracker.Fail(ctx, err)
 	}
-	artifact2.Description = "Photo 2"
-	result.Artifacts = append(result.Artifacts, artifact2)
-	result.UpdatedAt = workflow.Now(ctx).UTC().Format(format)
+	setLabel(artifact2, "Photo 2")
+	tracker.AddArtifact(ctx, artifact2)
 
-	result.Status = "Completed"
-	logger.Info("Random Unsplash workflow completed.", "result", result)
-
-	return result, nil
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusCompleted); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	logger.Info("Random Unsplash workflow completed.", "result", tracker.Update())
+	return tracker.Update(), nil
 }
 
-// initActivity
+// initActivity moved runs of version 1 to Running. It is kept for the runs that are still in flight.
 func initActivity(ctx context.Context, params map[string]interface{}) (string, error) {
 	return "Running", nil
 }
 
+// DefaultUnsplashURL is the base URL of the Unsplash Source API
+const DefaultUnsplashURL = "https://source.unsplash.com"
+
+// UnsplashActivities fetch photos from Unsplash Source. The zero value calls the real API.
+type UnsplashActivities struct {
+	// BaseURL of Unsplash Source. Defaults to DefaultUnsplashURL.
+	BaseURL string
+	// HTTPClient is used for the API calls, redirects are never followed. Defaults to a client with a 1 second
+	// timeout.
+	HTTPClient *http.Client
+	// Now returns the time the artifacts are created at. Defaults to time.Now.
+	Now func() time.Time
+	// Mirror stores a copy of the photo in the artifact store when set, the artifact links to the copy
+	Mirror *Mirror
+}
+
 // FetchRandomUnsplashActivity calls unsplash to get a random photo
-func FetchRandomUnsplashActivity(ctx context.Context, params map[string]interface{}) (*schema.DocumentCreate, error) {
+func (a *UnsplashActivities) FetchRandomUnsplashActivity(ctx context.Context, params map[string]interface{}) (*schema.DocumentCreate, error) {
 	logger := activity.GetLogger(ctx)
 	logger.Info("FetchRandomUnsplashActivity", "name", params["workflow_instance_id"])
 
-	requestURL := "https://source.unsplash.com/random/400x320?"
+	baseURL := DefaultUnsplashURL
+	if a.BaseURL != "" {
+		baseURL = trimURL(a.BaseURL)
+	}
+	requestURL := baseURL + "/random/400x320?"
 	var term string
 	if foo, ok := params["term"]; ok {
 		if term, ok = foo.(string); ok {
@@ -120,12 +146,20 @@ func FetchRandomUnsplashActivity(ctx context.Context, params map[string]interfac
 		}
 	}
 
-	c := http.Client{Timeout: time.Duration(1) * time.Second,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
-		}}
+	// the location of the redirect is the photo
+	c := http.Client{Timeout: time.Duration(1) * time.Second}
+	if a.HTTPClient != nil {
+		c = *a.HTTPClient
+	}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
 
-	resp, err := c.Head(requestURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
 	if err != nil {
 		fmt.Printf("Error %s", err)
 		return nil, err
@@ -141,16 +175,20 @@ func FetchRandomUnsplashActivity(ctx context.Context, params map[string]interfac
 		filename = path.Base(parts.Path)
 	}
 
+	now := currentTime(a.Now)
 	artifact := &schema.DocumentCreate{
-		CreatedAt:    time.Now().UTC().Format(format),
-		Description:  "Random photo from Unsplash",
+		CreatedAt:    schema.Time(now),
+		Description:  schema.String("Random photo from Unsplash"),
 		Evergreen:    false,
 		Name:         filename,
-		ResourceId:   filename,
-		ResourceType: "",
+		ResourceId:   schema.String(filename),
+		ResourceType: nil,
 		Filters:      []*schema.SearchFilterRead{},
-		UpdatedAt:    time.Now().UTC().Format(format),
-		Weblink:      newLocation,
+		UpdatedAt:    schema.Time(now),
+		Weblink:      schema.String(newLocation),
+	}
+	if a.Mirror != nil {
+		return a.Mirror.Copy(ctx, &c, newLocation, artifact)
 	}
 
 	return artifact, nil
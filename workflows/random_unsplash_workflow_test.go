@@ -0,0 +1,86 @@
+package workflows_test
+
+import (
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"github.com/stretchr/testify/mock"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomUnsplashWorkflow(t *testing.T) {
+	env := workflowtest.New(t)
+	var activities *workflows.UnsplashActivities
+	env.OnActivity(activities.FetchRandomUnsplashActivity, mock.Anything, mock.Anything).
+		Return(workflowtest.Artifact("photo-1.jpg"), nil).Once()
+	env.OnActivity(activities.FetchRandomUnsplashActivity, mock.Anything, mock.Anything).
+		Return(workflowtest.Artifact("photo-2.jpg"), nil).Once()
+
+	env.At(time.Second, func(state *schema.WorkflowInstanceUpdate) {
+		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusRunning)
+		workflowtest.AssertArtifacts(t, state)
+	})
+	env.At(20*time.Second, func(state *schema.WorkflowInstanceUpdate) {
+		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusRunning)
+		workflowtest.AssertArtifacts(t, state, "photo-1.jpg")
+	})
+
+	result := env.Run(workflows.RandomUnsplashWorkflow, workflowtest.Params(map[string]interface{}{"term": "nature"}))
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	workflowtest.AssertArtifacts(t, result, "photo-1.jpg", "photo-2.jpg")
+	if got := schema.StringValue(result.Artifacts[1].Description); got != "Photo 2" {
+		t.Errorf("description of the second artifact is %q", got)
+	}
+	if !result.UpdatedAt.After(result.CreatedAt) {
+		t.Errorf("updated_at %s is not after created_at %s", result.UpdatedAt, result.CreatedAt)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestRandomUnsplashWorkflowFailed(t *testing.T) {
+	env := workflowtest.New(t)
+	var activities *workflows.UnsplashActivities
+	env.OnActivity(activities.FetchRandomUnsplashActivity, mock.Anything, mock.Anything).
+		Return(nil, errors.New("unsplash is down"))
+
+	state, err := env.RunError(workflows.RandomUnsplashWorkflow, workflowtest.Params(map[string]interface{}{"term": "nature"}))
+	if err == nil {
+		t.Fatal("expected the workflow to fail")
+	}
+	workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusFailed)
+	workflowtest.AssertArtifacts(t, state)
+	if state.RunReason == nil {
+		t.Error("run_reason is not set")
+	}
+}
+
+func TestRandomUnsplashWorkflowOffline(t *testing.T) {
+	fake := workflowtest.NewUnsplash()
+	defer fake.Close()
+	created := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	env := workflowtest.New(t)
+	env.RegisterActivity(&workflows.UnsplashActivities{
+		BaseURL:    fake.BaseURL(),
+		HTTPClient: fake.Client(),
+		Now:        func() time.Time { return created },
+	})
+
+	result := env.Run(workflows.RandomUnsplashWorkflow, workflowtest.Params(map[string]interface{}{"term": "nature"}))
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	workflowtest.AssertArtifacts(t, result, "photo-1", "photo-2")
+	for _, a := range result.Artifacts {
+		if !strings.HasPrefix(schema.StringValue(a.Weblink), fake.URL) {
+			t.Errorf("weblink %s doesn't point to the fake", schema.StringValue(a.Weblink))
+		}
+		if !a.CreatedAt.Equal(created) {
+			t.Errorf("artifact created at %s, want %s", a.CreatedAt, created)
+		}
+	}
+	if terms := fake.Terms(); len(terms) != 2 || terms[0] != "nature" {
+		t.Errorf("unexpected search terms %v", terms)
+	}
+}
@@ -0,0 +1,147 @@
+package workflows
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultWeblink is the link template used for workflows that don't define their own. It points to the
+// execution history of the run in the Temporal Web UI.
+const DefaultWeblink = "{{.WebURL}}/namespaces/{{.Namespace}}/workflows/{{.WorkflowID}}/{{.RunID}}/history"
+
+// Definition describes a workflow that can be started from Dispatch
+type Definition struct {
+	// Name is the resource id of the workflow in Dispatch
+	Name string
+	// Workflow is the workflow function
+	Workflow interface{}
+	// Activities are the activity functions and structs the workflow executes
+	Activities []interface{}
+	// Configure is called by the worker with its environment before the activities are registered, to set
+	// the dependencies of activity structs. Optional.
+	Configure func(env Environment)
+	// Weblink is a text/template for the link Dispatch shows for a run of the workflow. The template gets
+	// the fields of LinkData. Defaults to DefaultWeblink.
+	Weblink string
+	// Policy holds the timeouts and retries of the workflow and its activities. The configuration file of td
+	// can override them.
+	Policy Policy
+	// Version is the revision of the workflow code. It is recorded for every run and reported to Dispatch as
+	// the code_version parameter. Bump it with every change to the workflow, see Changed. Defaults to 1.
+	Version string
+	// Internal workflows are started by td itself, like ScheduleWorkflow. The worker and the replayer register
+	// them, but they can't be started by name through the API or in a playbook.
+	Internal bool
+
+	weblink *template.Template
+}
+
+// LinkData is passed to the weblink templates of workflow definitions
+type LinkData struct {
+	// WebURL is the base URL of the Temporal Web UI without a trailing slash
+	WebURL string
+	// Namespace is the Temporal namespace of the run
+	Namespace string
+	// Name is the name of the workflow in the registry
+	Name string
+	// WorkflowID is the path escaped Temporal workflow id
+	WorkflowID string
+	// RunID is the path escaped Temporal run id
+	RunID string
+}
+
+var registry = map[string]*Definition{}
+
+// Register adds a workflow definition to the registry so it can be started through the API. It panics when
+// the definition is invalid, as registration is expected to happen from init functions.
+func Register(def Definition) {
+	if def.Name == "" || def.Workflow == nil {
+		panic("workflow definition needs a name and a workflow function")
+	}
+	if _, exists := registry[def.Name]; exists {
+		panic(fmt.Sprintf("workflow %q is already registered", def.Name))
+	}
+	if def.Weblink == "" {
+		def.Weblink = DefaultWeblink
+	}
+	if def.Version == "" {
+		def.Version = "1"
+	}
+	def.weblink = template.Must(template.New(def.Name).Parse(def.Weblink))
+
+	registry[def.Name] = &def
+}
+
+// lookup returns the definition registered under name, internal workflows are not found
+func lookup(name string) (*Definition, bool) {
+	def, ok := registry[name]
+	if !ok || def.Internal {
+		return nil, false
+	}
+	return def, true
+}
+
+// Definitions returns the registered workflow definitions sorted by name, including the internal workflows
+func Definitions() []*Definition {
+	defs := make([]*Definition, 0, len(registry))
+	for _, def := range registry {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool {
+		return defs[i].Name < defs[j].Name
+	})
+	return defs
+}
+
+// lookupByType returns the definition of the workflow function registered with Temporal under typeName
+func lookupByType(typeName string) (*Definition, bool) {
+	for _, def := range registry {
+		if functionName(def.Workflow) == typeName {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+// functionName returns the name Temporal registers a function under, which is the name without its package
+func functionName(fn interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// link renders the weblink template of the definition
+func (def *Definition) link(data LinkData) (string, error) {
+	data.Name = def.Name
+	var buf bytes.Buffer
+	if err := def.weblink.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// registeredActivities returns the activities of all definitions, each activity only once
+func registeredActivities() []interface{} {
+	seen := map[uintptr]bool{}
+	var activities []interface{}
+	for _, def := range registry {
+		for _, a := range def.Activities {
+			ptr := reflect.ValueOf(a).Pointer()
+			if seen[ptr] {
+				continue
+			}
+			seen[ptr] = true
+			activities = append(activities, a)
+		}
+	}
+	return activities
+}
+
+// trimURL removes trailing slashes so templates can safely append paths
+func trimURL(u string) string {
+	return strings.TrimRight(u, "/")
+}
@@ -0,0 +1,11 @@
+package workflows_test
+
+import (
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"testing"
+)
+
+func TestReplay(t *testing.T) {
+	workflowtest.Replay(t, "testdata/histories", workflows.Definitions())
+}
@@ -0,0 +1,85 @@
+package workflows
+
+import (
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"go.temporal.io/sdk/workflow"
+	"strings"
+)
+
+// compensationResourceType is reported to Dispatch as the type of the artifacts of compensations
+const compensationResourceType = "td-compensation"
+
+// Saga undoes the steps of a remediation workflow when a later step fails or the workflow is canceled. After a
+// step succeeds, the workflow adds the activity that reverts it:
+//
+//	saga := NewSaga(tracker)
+//	if err := workflow.ExecuteActivity(ctx, a.ScaleUpActivity, params).Get(ctx, nil); err != nil {
+//		return nil, saga.Compensate(ctx, err)
+//	}
+//	saga.AddCompensation("scale up", a.ScaleDownActivity, params)
+//
+// Compensate runs the compensations in reverse order, records each of them as artifact and fails the run.
+type Saga struct {
+	tracker       *Tracker
+	compensations []compensation
+}
+
+type compensation struct {
+	step     string
+	activity interface{}
+	args     []interface{}
+}
+
+// NewSaga returns a saga that reports its compensations to the tracker of the run
+func NewSaga(tracker *Tracker) *Saga {
+	return &Saga{tracker: tracker}
+}
+
+// AddCompensation registers the activity that reverts step, it is executed with args
+func (s *Saga) AddCompensation(step string, activity interface{}, args ...interface{}) {
+	s.compensations = append(s.compensations, compensation{step: step, activity: activity, args: args})
+}
+
+// Compensate runs the compensations in reverse order of the steps and returns cause, so workflows can return
+// saga.Compensate(ctx, err). The compensations run with the activity options of ctx, also when the workflow is
+// canceled. A failing compensation doesn't stop the others. Every compensation is added as artifact and the run
+// fails with the cause and the outcome of the compensations as run reason.
+func (s *Saga) Compensate(ctx workflow.Context, cause error) error {
+	logger := workflow.GetLogger(ctx)
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+
+	var reverted, failed []string
+	for i := len(s.compensations) - 1; i >= 0; i-- {
+		c := s.compensations[i]
+		now := workflow.Now(ctx).UTC()
+		artifact := &schema.DocumentCreate{
+			CreatedAt:    schema.Time(now),
+			Name:         "Rollback: " + c.step,
+			ResourceId:   schema.String(fmt.Sprintf("%s/compensation-%d", workflow.GetInfo(ctx).WorkflowExecution.RunID, i+1)),
+			ResourceType: schema.String(compensationResourceType),
+			Filters:      []*schema.SearchFilterRead{},
+			UpdatedAt:    schema.Time(now),
+		}
+		if err := workflow.ExecuteActivity(ctx, c.activity, c.args...).Get(ctx, nil); err != nil {
+			logger.Error("Compensation failed.", "step", c.step, "Error", err)
+			failed = append(failed, c.step)
+			artifact.Description = schema.String(fmt.Sprintf("Reverting %s failed: %s", c.step, err))
+		} else {
+			reverted = append(reverted, c.step)
+			artifact.Description = schema.String("Reverted " + c.step)
+		}
+		s.tracker.AddArtifact(ctx, artifact)
+	}
+	s.compensations = nil
+
+	reason := cause.Error()
+	if len(reverted) > 0 {
+		reason += "; rolled back: " + strings.Join(reverted, ", ")
+	}
+	if len(failed) > 0 {
+		reason += "; rollback failed: " + strings.Join(failed, ", ")
+	}
+	s.tracker.fail(ctx, reason)
+	return cause
+}
@@ -0,0 +1,94 @@
+package workflows_test
+
+import (
+	"context"
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"strings"
+	"testing"
+	"time"
+)
+
+func drainActivity(ctx context.Context, host string) error    { return nil }
+func undrainActivity(ctx context.Context, host string) error  { return nil }
+func restartActivity(ctx context.Context, host string) error  { return nil }
+func rollbackActivity(ctx context.Context, host string) error { return nil }
+
+// remediationWorkflow drains a host and restarts it, the restart waits a minute for the drain to settle
+func remediationWorkflow(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 10 * time.Second,
+		RetryPolicy:         &temporal.RetryPolicy{MaximumAttempts: 1},
+	})
+	tracker, err := workflows.NewTracker(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusRunning); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+
+	saga := workflows.NewSaga(tracker)
+	if err := workflow.ExecuteActivity(ctx, drainActivity, "web-1").Get(ctx, nil); err != nil {
+		return nil, saga.Compensate(ctx, err)
+	}
+	saga.AddCompensation("drain web-1", undrainActivity, "web-1")
+	if err := workflow.Sleep(ctx, time.Minute); err != nil {
+		return nil, saga.Compensate(ctx, err)
+	}
+	if err := workflow.ExecuteActivity(ctx, restartActivity, "web-1").Get(ctx, nil); err != nil {
+		return nil, saga.Compensate(ctx, err)
+	}
+	saga.AddCompensation("restart web-1", rollbackActivity, "web-1")
+
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusCompleted); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	return tracker.Update(), nil
+}
+
+func TestSagaCompensate(t *testing.T) {
+	env := workflowtest.New(t)
+	env.OnActivity(drainActivity, mock.Anything, "web-1").Return(nil).Once()
+	env.OnActivity(restartActivity, mock.Anything, "web-1").Return(errors.New("host is unreachable")).Once()
+	env.OnActivity(undrainActivity, mock.Anything, "web-1").Return(nil).Once()
+	env.RegisterActivity(rollbackActivity)
+
+	state, err := env.RunError(remediationWorkflow, workflowtest.Params(nil))
+	if err == nil {
+		t.Fatal("expected the workflow to fail")
+	}
+	workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusFailed)
+	workflowtest.AssertArtifacts(t, state, "Rollback: drain web-1")
+	if got := schema.StringValue(state.Artifacts[0].Description); got != "Reverted drain web-1" {
+		t.Errorf("description is %q", got)
+	}
+	if got := schema.StringValue(state.RunReason); !strings.Contains(got, "rolled back: drain web-1") {
+		t.Errorf("run_reason is %q", got)
+	}
+	env.AssertExpectations(t)
+}
+
+func TestSagaCompensateCanceled(t *testing.T) {
+	env := workflowtest.New(t)
+	env.OnActivity(drainActivity, mock.Anything, "web-1").Return(nil).Once()
+	env.OnActivity(undrainActivity, mock.Anything, "web-1").Return(errors.New("load balancer is down"))
+	env.RegisterActivity(restartActivity)
+	env.RegisterActivity(rollbackActivity)
+	env.RegisterDelayedCallback(env.CancelWorkflow, 30*time.Second)
+
+	state, err := env.RunError(remediationWorkflow, workflowtest.Params(nil))
+	if err == nil {
+		t.Fatal("expected the workflow to be canceled")
+	}
+	workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusFailed)
+	workflowtest.AssertArtifacts(t, state, "Rollback: drain web-1")
+	if got := schema.StringValue(state.RunReason); !strings.Contains(got, "rollback failed: drain web-1") {
+		t.Errorf("run_reason is %q", got)
+	}
+}
@@ -0,0 +1,195 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/robfig/cron"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"time"
+)
+
+const (
+	// IncidentClosedSignal stops a scheduled workflow after the run in progress
+	IncidentClosedSignal = "incident_closed"
+	// MinScheduleInterval is the shortest interval a workflow can be scheduled with
+	MinScheduleInterval = time.Minute
+	// scheduleRunsPerExecution is the number of runs after which a schedule continues as new, to keep its
+	// history small
+	scheduleRunsPerExecution = 100
+	// scheduleMaxArtifacts is the number of artifacts of the latest runs a schedule reports
+	scheduleMaxArtifacts = 50
+)
+
+func init() {
+	Register(Definition{
+		Name:     "schedule",
+		Workflow: ScheduleWorkflow,
+		Internal: true,
+	})
+}
+
+// Schedule runs a registered workflow repeatedly during an incident, on a cron schedule or at an interval
+type Schedule struct {
+	// Cron is a standard cron expression with five fields, evaluated in UTC
+	Cron string `json:"cron,omitempty"`
+	// Interval between the starts of two runs
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// ScheduleInput is the input of ScheduleWorkflow. Runs is carried over when it continues as new, the state of the
+// schedule is carried in Params.
+type ScheduleInput struct {
+	// Workflow is the name of the registered workflow to run
+	Workflow string
+	Params   map[string]interface{}
+	Schedule Schedule
+	Runs     int
+}
+
+// ScheduleFrom returns the schedule from the schedule or interval parameter. It returns nil when neither is set.
+func ScheduleFrom(params map[string]interface{}) (*Schedule, error) {
+	s := &Schedule{}
+	if v, ok := params["schedule"].(string); ok && v != "" {
+		s.Cron = v
+	}
+	if v, ok := params["interval"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %w", v, err)
+		}
+		s.Interval = Duration(d)
+	}
+	if s.Cron == "" && s.Interval == 0 {
+		return nil, nil
+	}
+	return s, s.validate()
+}
+
+func (s Schedule) validate() error {
+	switch {
+	case s.Cron != "" && s.Interval != 0:
+		return errors.New("a workflow can't have both a schedule and an interval")
+	case s.Cron != "":
+		if _, err := cron.ParseStandard(s.Cron); err != nil {
+			return fmt.Errorf("invalid schedule %q: %w", s.Cron, err)
+		}
+	case time.Duration(s.Interval) < MinScheduleInterval:
+		return fmt.Errorf("the interval must be at least %s", MinScheduleInterval)
+	}
+	return nil
+}
+
+// next returns the start of the next run after now
+func (s Schedule) next(now time.Time) (time.Time, error) {
+	if s.Cron == "" {
+		return now.Add(time.Duration(s.Interval)), nil
+	}
+	schedule, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.Next(now.UTC()), nil
+}
+
+// String describes the schedule for Dispatch
+func (s Schedule) String() string {
+	if s.Cron != "" {
+		return s.Cron
+	}
+	return "every " + time.Duration(s.Interval).String()
+}
+
+// ScheduleWorkflow runs a registered workflow as child workflow on a schedule, until the incident_closed signal
+// arrives or the workflow is canceled. The artifacts of the runs are reported as its own. A schedule that is
+// stopped by the signal completes, a canceled schedule is reported as Failed to Dispatch.
+func ScheduleWorkflow(ctx workflow.Context, in ScheduleInput) (*schema.WorkflowInstanceUpdate, error) {
+	logger := workflow.GetLogger(ctx)
+
+	def, ok := lookup(in.Workflow)
+	if !ok {
+		return nil, temporal.NewNonRetryableApplicationError(fmt.Sprintf("workflow %q is not registered", in.Workflow), "UnknownWorkflow", nil)
+	}
+	tracker, err := NewTracker(ctx, in.Params)
+	if err != nil {
+		return nil, err
+	}
+	update := tracker.Update()
+	tracker.setParameter("schedule", in.Schedule.String())
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusRunning); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+
+	// closedStop completes the schedule after the incident_closed signal
+	closedStop := func() (*schema.WorkflowInstanceUpdate, error) {
+		logger.Info("Schedule stopped", "reason", "incident closed")
+		update.RunReason = schema.String(fmt.Sprintf("stopped after %d runs: incident closed", in.Runs))
+		if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusCompleted); err != nil {
+			return nil, tracker.Fail(ctx, err)
+		}
+		return tracker.Update(), nil
+	}
+
+	closed := workflow.GetSignalChannel(ctx, IncidentClosedSignal)
+	for run := 0; run < scheduleRunsPerExecution; run++ {
+		next, err := in.Schedule.next(workflow.Now(ctx))
+		if err != nil {
+			return nil, tracker.Fail(ctx, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidSchedule", err))
+		}
+
+		var canceled error
+		incidentClosed := false
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		selector := workflow.NewSelector(ctx)
+		selector.AddFuture(workflow.NewTimer(timerCtx, next.Sub(workflow.Now(ctx))), func(f workflow.Future) {
+			canceled = f.Get(ctx, nil)
+		})
+		selector.AddReceive(closed, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+			incidentClosed = true
+		})
+		selector.Select(ctx)
+		cancelTimer()
+
+		if incidentClosed {
+			return closedStop()
+		}
+		if canceled != nil {
+			logger.Info("Schedule stopped", "reason", "canceled")
+			// the workflow context is canceled, the final state is published with a disconnected context. The
+			// cancellation is returned, so the run is closed as canceled in Temporal as well.
+			ctx, _ := workflow.NewDisconnectedContext(ctx)
+			tracker.fail(ctx, fmt.Sprintf("canceled after %d runs", in.Runs))
+			return nil, canceled
+		}
+
+		in.Runs++
+		var result *schema.WorkflowInstanceUpdate
+		err = executeChild(ctx, def, fmt.Sprintf("run-%d", in.Runs), in.Params).Get(ctx, &result)
+		reason := fmt.Sprintf("run %d completed", in.Runs)
+		if err != nil {
+			logger.Warn("Scheduled run failed", "run", in.Runs, "Error", err)
+			reason = fmt.Sprintf("run %d failed: %s", in.Runs, err)
+		}
+		if result != nil {
+			for _, a := range result.Artifacts {
+				a.Description = schema.String(fmt.Sprintf("Run %d: %s", in.Runs, schema.StringValue(a.Description)))
+			}
+			update.Artifacts = append(update.Artifacts, result.Artifacts...)
+			if n := len(update.Artifacts); n > scheduleMaxArtifacts {
+				update.Artifacts = update.Artifacts[n-scheduleMaxArtifacts:]
+			}
+		}
+		update.RunReason = schema.String(reason)
+		tracker.changed(ctx)
+	}
+
+	// a signal that arrived during the last run would be lost when the schedule continues as new
+	if closed.ReceiveAsync(nil) {
+		return closedStop()
+	}
+	tracker.flush(ctx)
+	in.Params = tracker.Carry(in.Params)
+	return nil, workflow.NewContinueAsNewError(ctx, ScheduleWorkflow, in)
+}
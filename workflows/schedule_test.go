@@ -0,0 +1,160 @@
+package workflows_test
+
+import (
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduleWorkflow(t *testing.T) {
+	env := workflowtest.New(t)
+	env.RegisterWorkflow(workflows.RandomDogWorkflow)
+	run := 0
+	env.OnWorkflow(workflows.RandomDogWorkflow, mock.Anything, mock.Anything).Return(
+		func(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+			run++
+			if run == 2 {
+				return nil, errors.New("dog.ceo is down")
+			}
+			artifact := workflowtest.Artifact("dog.jpg")
+			artifact.Description = schema.String("Dog 1")
+			return &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusCompleted, Artifacts: []*schema.DocumentCreate{artifact}}, nil
+		})
+
+	env.At(90*time.Second, func(state *schema.WorkflowInstanceUpdate) {
+		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusRunning)
+		workflowtest.AssertArtifacts(t, state, "dog.jpg")
+	})
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(workflows.IncidentClosedSignal, nil)
+	}, 3*time.Minute+30*time.Second)
+
+	env.ExecuteWorkflow(workflows.ScheduleWorkflow, workflows.ScheduleInput{
+		Workflow: "random_dog",
+		Params:   workflowtest.Params(nil),
+		Schedule: workflows.Schedule{Interval: workflows.Duration(time.Minute)},
+	})
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("workflow failed: %v", err)
+	}
+	var result *schema.WorkflowInstanceUpdate
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatal(err)
+	}
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	workflowtest.AssertArtifacts(t, result, "dog.jpg", "dog.jpg")
+	if got := schema.StringValue(result.Artifacts[1].Description); got != "Run 3: Dog 1" {
+		t.Errorf("description of the last artifact is %q", got)
+	}
+	if got := schema.StringValue(result.RunReason); got != "stopped after 3 runs: incident closed" {
+		t.Errorf("run_reason is %q", got)
+	}
+}
+
+func TestScheduleWorkflowCanceled(t *testing.T) {
+	env := workflowtest.New(t)
+	env.RegisterWorkflow(workflows.RandomDogWorkflow)
+	env.OnWorkflow(workflows.RandomDogWorkflow, mock.Anything, mock.Anything).
+		Return(&schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusCompleted}, nil)
+	env.RegisterDelayedCallback(env.CancelWorkflow, 90*time.Second)
+
+	env.ExecuteWorkflow(workflows.ScheduleWorkflow, workflows.ScheduleInput{
+		Workflow: "random_dog",
+		Params:   workflowtest.Params(nil),
+		Schedule: workflows.Schedule{Cron: "* * * * *"},
+	})
+	if !temporal.IsCanceledError(env.GetWorkflowError()) {
+		t.Errorf("expected the schedule to close as canceled, got %v", env.GetWorkflowError())
+	}
+	state := env.State()
+	workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusFailed)
+	// the number of runs depends on the start of the test clock within the minute of the cron schedule
+	if got := schema.StringValue(state.RunReason); !strings.HasPrefix(got, "canceled after ") || !strings.HasSuffix(got, " runs") {
+		t.Errorf("run_reason is %q", got)
+	}
+}
+
+func TestScheduleWorkflowClosedBeforeContinueAsNew(t *testing.T) {
+	env := workflowtest.New(t)
+	env.RegisterWorkflow(workflows.RandomDogWorkflow)
+	env.OnWorkflow(workflows.RandomDogWorkflow, mock.Anything, mock.Anything).Return(
+		func(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+			err := workflow.Sleep(ctx, 30*time.Second)
+			return &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusCompleted}, err
+		})
+	// every run starts a minute after the previous one finished, the signal arrives during the 100th run
+	env.RegisterDelayedCallback(func() {
+		env.SignalWorkflow(workflows.IncidentClosedSignal, nil)
+	}, 100*time.Minute+99*30*time.Second+10*time.Second)
+
+	env.ExecuteWorkflow(workflows.ScheduleWorkflow, workflows.ScheduleInput{
+		Workflow: "random_dog",
+		Params:   workflowtest.Params(nil),
+		Schedule: workflows.Schedule{Interval: workflows.Duration(time.Minute)},
+	})
+	if err := env.GetWorkflowError(); err != nil {
+		t.Fatalf("the schedule didn't stop: %v", err)
+	}
+	var result *schema.WorkflowInstanceUpdate
+	if err := env.GetWorkflowResult(&result); err != nil {
+		t.Fatal(err)
+	}
+	if got := schema.StringValue(result.RunReason); got != "stopped after 100 runs: incident closed" {
+		t.Errorf("run_reason is %q", got)
+	}
+}
+
+func TestScheduleFrom(t *testing.T) {
+	tests := []struct {
+		params map[string]interface{}
+		want   string
+		err    bool
+	}{
+		{params: map[string]interface{}{}},
+		{params: map[string]interface{}{"interval": "5m"}, want: "every 5m0s"},
+		{params: map[string]interface{}{"schedule": "*/10 * * * *"}, want: "*/10 * * * *"},
+		{params: map[string]interface{}{"interval": "10s"}, err: true},
+		{params: map[string]interface{}{"interval": "often"}, err: true},
+		{params: map[string]interface{}{"schedule": "every minute"}, err: true},
+		{params: map[string]interface{}{"schedule": "* * * * *", "interval": "5m"}, err: true},
+	}
+	for _, test := range tests {
+		s, err := workflows.ScheduleFrom(test.params)
+		if (err != nil) != test.err {
+			t.Errorf("ScheduleFrom(%v) returned error %v", test.params, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		got := ""
+		if s != nil {
+			got = s.String()
+		}
+		if got != test.want {
+			t.Errorf("ScheduleFrom(%v) = %q, want %q", test.params, got, test.want)
+		}
+	}
+}
+
+func TestScheduleRegistered(t *testing.T) {
+	found := false
+	for _, def := range workflows.Definitions() {
+		if def.Name == "schedule" {
+			found = def.Internal
+		}
+	}
+	if !found {
+		t.Error("the schedule workflow isn't registered as internal workflow, so it isn't replayed")
+	}
+	if _, err := workflows.PlaybookFrom(map[string]interface{}{"workflows": "schedule"}); err == nil {
+		t.Error("a playbook can run the internal schedule workflow")
+	}
+}
@@ -0,0 +1,51 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/interceptors"
+	"go.temporal.io/sdk/workflow"
+)
+
+// workerSettings is the configuration of a worker that its workflows read. The worker passes it to every run in
+// the workflow context, with settingsInterceptor.
+type workerSettings struct {
+	// policies are the configured policies by workflow name. Timeouts and retries aren't part of the history
+	// checked on replay, so workflows can read them directly.
+	policies map[string]Policy
+	// callbacks is set when a callback URL is configured. Workflows read it once through a side effect, so runs
+	// keep their setting when the worker is restarted with a different configuration.
+	callbacks bool
+}
+
+type settingsKey struct{}
+
+// withSettings returns a workflow context with the settings of the worker
+func withSettings(ctx workflow.Context, s *workerSettings) workflow.Context {
+	return workflow.WithValue(ctx, settingsKey{}, s)
+}
+
+// settingsFrom returns the settings of the worker that runs the workflow. Runs without settings, like in the test
+// environment, get the defaults.
+func settingsFrom(ctx workflow.Context) *workerSettings {
+	if s, ok := ctx.Value(settingsKey{}).(*workerSettings); ok {
+		return s
+	}
+	return &workerSettings{}
+}
+
+// settingsInterceptor passes the settings of the worker to every workflow run it executes
+type settingsInterceptor struct {
+	settings *workerSettings
+}
+
+func (i *settingsInterceptor) InterceptWorkflow(info *workflow.Info, next interceptors.WorkflowInboundCallsInterceptor) interceptors.WorkflowInboundCallsInterceptor {
+	return &settingsInbound{WorkflowInboundCallsInterceptorBase: interceptors.WorkflowInboundCallsInterceptorBase{Next: next}, settings: i.settings}
+}
+
+type settingsInbound struct {
+	interceptors.WorkflowInboundCallsInterceptorBase
+	settings *workerSettings
+}
+
+func (i *settingsInbound) ExecuteWorkflow(ctx workflow.Context, workflowType string, args ...interface{}) []interface{} {
+	return i.Next.ExecuteWorkflow(withSettings(ctx, i.settings), workflowType, args...)
+}
@@ -2,73 +2,231 @@ package workflows
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/dispatch"
 	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/schema/versions"
+	enums "go.temporal.io/api/enums/v1"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptors"
 	"go.temporal.io/sdk/worker"
 	"log"
+	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 )
 
-const format = "2006-01-02 15:04:05"
+const (
+	// resourceType is reported to Dispatch as the type of the resource behind a workflow instance
+	resourceType = "temporal-workflow"
+	// startTimeout limits how long Start takes, including the state query of the new run. It stays below the
+	// timeout of the API, so a run that started is answered with its state instead of a timeout that Dispatch retries.
+	startTimeout = 3 * time.Second
+	// startQueryTimeout limits how long Start waits for a new workflow to report its state
+	startQueryTimeout = 2 * time.Second
+	// provenanceParameter is the key of the Dispatch parameter with the link to the run that produced the artifacts
+	provenanceParameter = "produced_by"
+)
+
+// ErrWorkflowNotFound is returned when there is no workflow with the requested id
+var ErrWorkflowNotFound = errors.New("workflow not found")
+
+// ErrWorkflowAlreadyStarted is returned by Start when a run of the workflow instance is open already
+var ErrWorkflowAlreadyStarted = errors.New("workflow already started")
+
+// ValidationError is returned by Start when the parameters of a start are invalid, like a schedule that can't be
+// parsed
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
 
-var availableWorkflows map[string]interface{}
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Options configures the connection to Temporal
+type Options struct {
+	// HostPort of the Temporal frontend. Defaults to localhost:7233
+	HostPort string
+	// Namespace to run the workflows in. Defaults to default
+	Namespace string
+	// Queue is the task queue the worker listens on. Defaults to dispatch
+	Queue string
+	// WebURL is the base URL of the Temporal Web UI used for links in Dispatch. Defaults to http://localhost:8088
+	WebURL string
+	// CallbackURL receives the state of a workflow on every status change and new artifact. Callbacks are
+	// disabled when empty, in which case Dispatch has to poll for updates.
+	CallbackURL string
+	// CallbackToken is sent as bearer token with every callback when set
+	CallbackToken string
+	// DispatchURL is the base URL of the Dispatch API, including the organization, that workflows use to
+	// read and update their incident. Incident activities fail when empty.
+	DispatchURL string
+	// DispatchToken is sent as bearer token to the Dispatch API
+	DispatchToken string
+	// DispatchVersion selects the shape of the updates posted to the callback URL. Defaults to versions.Latest.
+	DispatchVersion string
+	// LegacyTimes sends timestamps without time zone, in schema.LegacyDateTimeFormat, for older Dispatch versions
+	LegacyTimes bool
+	// Environment is passed to the activities of the registered workflows
+	Environment Environment
+	// Policies override the timeouts, retries and concurrency limits of the registered workflows by name
+	Policies map[string]Policy
+	// MaxConcurrentRuns limits the open runs of all workflows together. No limit when zero.
+	MaxConcurrentRuns int
+}
 
 //WorkflowClient holds the temporal client and queue
 type WorkflowClient struct {
-	hostPort string
-	queue    string
+	hostPort  string
+	namespace string
+	queue     string
+	webURL    string
+	notifier  *notifier
+	incidents *incidentActivities
+	env       Environment
+	maxRuns   int
+	policies  map[string]Policy
+
+	// mu guards the reserved starts and the queue
+	mu       sync.Mutex
+	reserved map[string]*reservation
+	queued   []*queuedStart
+	draining bool
+	// drain starts the queued starts in the background, it is replaced in tests
+	drain func()
+
+	// clientMu guards client, the connection to Temporal that all calls share
+	clientMu sync.Mutex
+	client   client.Client
 }
 
 // NewWorkflowStarter returns a new workflow starter with a temporal client
-func NewWorkflowStarter(hostPort, queue string) (*WorkflowClient, error) {
+func NewWorkflowStarter(options Options) (*WorkflowClient, error) {
 	s := &WorkflowClient{}
 
-	if queue == "" {
-		queue = "dispatch"
+	if options.Queue == "" {
+		options.Queue = "dispatch"
+	}
+	s.queue = options.Queue
+
+	if options.HostPort == "" {
+		options.HostPort = "localhost:7233"
 	}
-	s.queue = queue
+	s.hostPort = options.HostPort
 
-	if hostPort == "" {
-		hostPort = "localhost:7233"
+	if options.Namespace == "" {
+		options.Namespace = "default"
 	}
+	s.namespace = options.Namespace
+	s.env = options.Environment
+	s.maxRuns = options.MaxConcurrentRuns
+	s.policies = options.Policies
+	s.drain = s.drainQueue
 
-	s.hostPort = hostPort
+	if options.WebURL == "" {
+		options.WebURL = "http://localhost:8088"
+	}
+	if _, err := url.Parse(options.WebURL); err != nil {
+		return nil, fmt.Errorf("invalid Temporal Web URL: %w", err)
+	}
+	s.webURL = trimURL(options.WebURL)
+
+	if options.CallbackURL != "" {
+		if _, err := url.Parse(options.CallbackURL); err != nil {
+			return nil, fmt.Errorf("invalid callback URL: %w", err)
+		}
+	}
+	version, err := versions.Lookup(options.DispatchVersion)
+	if err != nil {
+		return nil, err
+	}
+	if options.LegacyTimes {
+		version = versions.WithLegacyTimes(version)
+	}
+	s.notifier = &notifier{
+		url:     options.CallbackURL,
+		token:   options.CallbackToken,
+		version: version,
+		client:  s,
+		http:    &http.Client{Timeout: 5 * time.Second},
+	}
+
+	s.incidents = &incidentActivities{}
+	if options.DispatchURL != "" {
+		d, err := dispatch.NewClient(dispatch.Options{BaseURL: options.DispatchURL, Token: options.DispatchToken})
+		if err != nil {
+			return nil, err
+		}
+		s.incidents.dispatch = d
+	}
 
 	return s, nil
 }
 
+// getClient returns the Temporal client. It is dialed on first use and shared by the worker and all calls, it
+// must not be closed by its callers.
 func (ws *WorkflowClient) getClient() (client.Client, error) {
+	ws.clientMu.Lock()
+	defer ws.clientMu.Unlock()
+	if ws.client != nil {
+		return ws.client, nil
+	}
 	c, err := client.NewClient(client.Options{
-		HostPort: ws.hostPort,
+		HostPort:  ws.hostPort,
+		Namespace: ws.namespace,
 	})
 
 	if err != nil {
 		log.Println("Unable to create client", err)
 		return nil, err
 	}
+	ws.client = c
 	return c, nil
 }
 
+// Close closes the connection to Temporal. The next call dials it again.
+func (ws *WorkflowClient) Close() {
+	ws.clientMu.Lock()
+	defer ws.clientMu.Unlock()
+	if ws.client != nil {
+		ws.client.Close()
+		ws.client = nil
+	}
+}
+
 // StartWorkflowWorker connects to temporal and listens for workflows
 func (ws *WorkflowClient) StartWorkflowWorker(ctx context.Context) {
 	c, err := ws.getClient()
 	if err != nil {
 		log.Fatalln("Unable to start worker", err)
 	}
-	defer c.Close()
-
-	w := worker.New(c, ws.queue, worker.Options{})
 
-	// dogs
-	w.RegisterWorkflow(RandomDogWorkflow)
-	w.RegisterActivity(FetchRandomDogActivity)
+	settings := &workerSettings{policies: ws.policies, callbacks: ws.notifier.url != ""}
+	w := worker.New(c, ws.queue, worker.Options{
+		WorkflowInterceptorChainFactories: []interceptors.WorkflowInterceptor{&settingsInterceptor{settings: settings}},
+	})
 
-	// unsplash
-	w.RegisterWorkflow(RandomUnsplashWorkflow)
-	w.RegisterActivity(initActivity)
-	w.RegisterActivity(FetchRandomUnsplashActivity)
+	for _, def := range registry {
+		w.RegisterWorkflow(def.Workflow)
+		if def.Configure != nil {
+			def.Configure(ws.env)
+		}
+	}
+	for _, a := range registeredActivities() {
+		w.RegisterActivity(a)
+	}
+	w.RegisterActivity(ws.notifier)
+	w.RegisterActivity(ws.incidents)
+	w.RegisterActivity(&ArtifactActivities{Storage: ws.env.Artifacts})
 
 	err = w.Run(worker.InterruptCh())
 	if err != nil {
@@ -77,8 +235,10 @@ func (ws *WorkflowClient) StartWorkflowWorker(ctx context.Context) {
 
 }
 
-// Start kicks of a workflow
-func (ws *WorkflowClient) Start(workflowID string, params map[string]interface{}) *schema.WorkflowInstanceUpdate {
+// Start kicks of a workflow. A ValidationError is returned when the schedule or interval is invalid. A LimitError
+// is returned when the concurrency limits of the workflow don't allow another run, unless the workflow queues its
+// starts. ErrWorkflowAlreadyStarted is returned when the instance runs already.
+func (ws *WorkflowClient) Start(workflowID string, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
 	/*
 			{
 		   	"workflow_id": "random_unsplash",
@@ -91,20 +251,24 @@ func (ws *WorkflowClient) Start(workflowID string, params map[string]interface{}
 		   }
 
 	*/
-	var startWorkflow interface{}
-	var workflowExists bool
-	if startWorkflow, workflowExists = availableWorkflows[workflowID]; !workflowExists {
+	def, workflowExists := lookup(workflowID)
+	if !workflowExists {
 		return &schema.WorkflowInstanceUpdate{
 			Artifacts:    []*schema.DocumentCreate{},
-			CreatedAt:    time.Now().UTC().Format(format),
+			CreatedAt:    time.Now().UTC(),
 			Parameters:   nil,
-			ResourceId:   "",
-			ResourceType: "",
-			RunReason:    "",
-			Status:       "Failed",
-			UpdatedAt:    time.Now().UTC().Format(format),
-			Weblink:      "",
-		}
+			ResourceId:   nil,
+			ResourceType: nil,
+			RunReason:    nil,
+			Status:       schema.WorkflowInstanceStatusFailed,
+			UpdatedAt:    time.Now().UTC(),
+			Weblink:      nil,
+		}, nil
+	}
+
+	schedule, err := ScheduleFrom(params)
+	if err != nil {
+		return nil, &ValidationError{Err: err}
 	}
 
 	log.Println("Start workflow params:", params)
@@ -122,89 +286,243 @@ func (ws *WorkflowClient) Start(workflowID string, params map[string]interface{}
 		log.Println("Instance_ID not set", params)
 	}
 	log.Println("Starting workflow ID: ", combinedID)
-	workflowOptions := client.StartWorkflowOptions{
-		ID:        combinedID,
-		TaskQueue: ws.queue,
+	if state, queued := ws.queuedState(combinedID); queued {
+		return state, nil
 	}
 
 	c, err := ws.getClient()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	defer c.Close()
 
-	we, err := c.ExecuteWorkflow(context.Background(), workflowOptions, startWorkflow, params)
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+	we, err := ws.admitAndExecute(ctx, c, def, combinedID, params, schedule)
+	var limit *LimitError
+	if errors.As(err, &limit) && policyFor(def, ws.policies).Concurrency.Queue {
+		ws.mu.Lock()
+		defer ws.mu.Unlock()
+		return ws.enqueue(&queuedStart{def: def, id: combinedID, params: params, schedule: schedule}, limit), nil
+	}
 	if err != nil {
-		log.Fatalln("Unable to execute workflow", err)
+		log.Println("Unable to execute workflow", err)
+		return nil, err
 	}
 
 	log.Println("Started workflow", "WorkflowID", we.GetID(), "RunID", we.GetRunID())
 
-	weblink := "https://google.com/"
-	switch workflowID {
-	case "random_dog":
-		weblink = "https://dog.ceo/"
-		break
-	case "random_unsplash":
-		weblink = "https://unsplash.com/"
-		break
+	result := ws.startState(ctx, c, we.GetID(), we.GetRunID())
+	result.ResourceId = schema.String(we.GetRunID())
+	result.ResourceType = schema.String(resourceType)
+	ws.addLinks(def, result, we.GetID(), we.GetRunID())
+
+	return result, nil
+}
+
+// startState queries the initial state of a workflow that was just started. The query can only be answered
+// once a worker picked up the first workflow task, so it is retried for a short while, and no longer than ctx
+// allows, before falling back to reporting the workflow as submitted.
+func (ws *WorkflowClient) startState(ctx context.Context, c client.Client, workflowID, runID string) *schema.WorkflowInstanceUpdate {
+	ctx, cancel := context.WithTimeout(ctx, startQueryTimeout)
+	defer cancel()
+
+	for {
+		result, err := queryState(ctx, c, workflowID, runID)
+		if err == nil && result.Status != "" {
+			return result
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Unable to query start state of workflow", workflowID, err)
+			return &schema.WorkflowInstanceUpdate{
+				Artifacts: []*schema.DocumentCreate{},
+				CreatedAt: time.Now().UTC(),
+				Status:    schema.WorkflowInstanceStatusSubmitted,
+				UpdatedAt: time.Now().UTC(),
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// addLinks points the weblink of a workflow instance to the link of its definition and reports the link as the
+// provenance of its artifacts in the produced_by parameter. Artifacts in the artifact store get a signed link to
+// download them, the other properties of the artifacts are left as the workflow reported them.
+func (ws *WorkflowClient) addLinks(def *Definition, update *schema.WorkflowInstanceUpdate, workflowID, runID string) {
+	ws.signArtifacts(update)
+
+	link, err := def.link(LinkData{
+		WebURL:     ws.webURL,
+		Namespace:  url.PathEscape(ws.namespace),
+		WorkflowID: url.PathEscape(workflowID),
+		RunID:      url.PathEscape(runID),
+	})
+	if err != nil {
+		log.Println("Unable to render weblink for workflow", def.Name, err)
+		return
+	}
+
+	update.Weblink = schema.String(link)
+	if len(update.Artifacts) > 0 {
+		setParameter(update, provenanceParameter, link)
+	}
+}
+
+// signArtifacts points the weblink of the artifacts in the artifact store to a signed download link. Workflows
+// only keep the key, so the links that expire aren't part of their state.
+func (ws *WorkflowClient) signArtifacts(update *schema.WorkflowInstanceUpdate) {
+	if ws.env.Artifacts == nil || ws.env.Artifacts.Links == nil {
+		return
+	}
+	for _, a := range update.Artifacts {
+		if schema.StringValue(a.ResourceType) == artifactResourceType && schema.StringValue(a.ResourceId) != "" {
+			a.Weblink = schema.String(ws.env.Artifacts.Links.URL(*a.ResourceId))
+		}
+	}
+}
+
+// Cancel requests the cancellation of a workflow. Scheduled workflows stop after the run in progress, queued
+// starts are removed from the queue.
+// ErrWorkflowNotFound is returned when Temporal doesn't know the workflow.
+func (ws *WorkflowClient) Cancel(workflowID, instanceID string) error {
+	if instanceID != "" {
+		workflowID += "-" + instanceID
+	}
+	c, err := ws.getClient()
+	if err != nil {
+		return err
 	}
 
-	return &schema.WorkflowInstanceUpdate{
-		Artifacts:    []*schema.DocumentCreate{},
-		CreatedAt:    time.Now().UTC().Format(format),
-		Parameters:   nil,
-		ResourceId:   "",
-		ResourceType: "",
-		RunReason:    "",
-		Status:       "Created",
-		UpdatedAt:    time.Now().UTC().Format(format),
-		Weblink:      weblink,
+	if ws.dequeue(workflowID) {
+		log.Println("Removed queued workflow ID: ", workflowID)
+		return nil
 	}
+	log.Println("Canceling workflow ID: ", workflowID)
+	return notFoundErr(c.CancelWorkflow(context.Background(), workflowID, ""))
+}
 
+// Query a workflow status. Running workflows are asked for their state and report the status they track, closed
+// workflows report the result they completed with and the status of the execution. ErrWorkflowNotFound is
+// returned when Temporal doesn't know the workflow.
+func (ws *WorkflowClient) Query(workflowID, instanceID string) (*schema.WorkflowInstanceUpdate, error) {
+	result, _, err := ws.QueryExecution(workflowID, instanceID)
+	return result, err
 }
 
-// Query a workflow status
-func (ws *WorkflowClient) Query(workflowID, instanceID string) *schema.WorkflowInstanceUpdate {
-	queryType := "state"
+// QueryExecution queries the workflow status like Query and reports whether its Temporal execution closed. The
+// status of a run can still change until then, a saga reports Failed while it compensates.
+func (ws *WorkflowClient) QueryExecution(workflowID, instanceID string) (*schema.WorkflowInstanceUpdate, bool, error) {
+	name := workflowID
 
 	if instanceID != "" {
 		workflowID += "-" + instanceID
 	}
+	if state, queued := ws.queuedState(workflowID); queued {
+		return state, false, nil
+	}
 	c, err := ws.getClient()
 	if err != nil {
 		log.Println("Unable to get client", err)
-		return nil
+		return nil, false, err
 	}
+
+	ctx := context.Background()
+
 	log.Println("Querying workflow ID: ", workflowID)
-	resp, err := c.QueryWorkflow(context.Background(), workflowID, "", queryType)
+	desc, err := c.DescribeWorkflowExecution(ctx, workflowID, "")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil
-		}
-		log.Println("Unable to query workflow", err)
-		return nil
+		return nil, false, notFoundErr(err)
 	}
-	result := &schema.WorkflowInstanceUpdate{}
-	if err := resp.Get(result); err != nil {
-		log.Println("Unable to decode query result", err)
-		return nil
+	info := desc.GetWorkflowExecutionInfo()
+	runID := info.GetExecution().GetRunId()
+
+	var result *schema.WorkflowInstanceUpdate
+	var runReason string
+	switch info.GetStatus() {
+	case enums.WORKFLOW_EXECUTION_STATUS_RUNNING:
+		if result, err = queryState(ctx, c, workflowID, runID); err != nil {
+			log.Println("Unable to query state of workflow", workflowID, err)
+		}
+	case enums.WORKFLOW_EXECUTION_STATUS_COMPLETED:
+		if err = c.GetWorkflow(ctx, workflowID, runID).Get(ctx, &result); err != nil {
+			return nil, false, err
+		}
+	default:
+		// the run has no result, but the state it reached can still be queried while its history is retained
+		if result, err = queryState(ctx, c, workflowID, runID); err != nil {
+			log.Println("Unable to query state of closed workflow", workflowID, err)
+		}
+		if err = c.GetWorkflow(ctx, workflowID, runID).Get(ctx, nil); err != nil {
+			runReason = err.Error()
+		}
 	}
 
 	if result == nil {
-		artifacts := make([]*schema.DocumentCreate, 0)
 		result = &schema.WorkflowInstanceUpdate{
-			Artifacts:    artifacts,
-			CreatedAt:    time.Now().UTC().Format(format),
-			Parameters:   nil,
-			ResourceId:   "",
-			ResourceType: "",
-			RunReason:    "",
-			Status:       "Created",
-			UpdatedAt:    time.Now().UTC().Format(format),
-			Weblink:      "https://google.com/",
+			Artifacts: []*schema.DocumentCreate{},
+			CreatedAt: info.GetStartTime().UTC(),
+		}
+	}
+	result.Status = reportedStatus(result.Status, info.GetStatus())
+	if len(result.Parameters) == 0 {
+		var version string
+		if p, ok := info.GetMemo().GetFields()[codeVersionParameter]; ok && converter.GetDefaultDataConverter().FromPayload(p, &version) == nil {
+			result.Parameters = []map[string]interface{}{{"key": codeVersionParameter, "value": version}}
 		}
 	}
+	if runReason != "" {
+		result.RunReason = schema.String(runReason)
+	}
+	if info.GetCloseTime() != nil && !info.GetCloseTime().IsZero() {
+		result.UpdatedAt = info.GetCloseTime().UTC()
+	}
+	result.ResourceId = schema.String(runID)
+	result.ResourceType = schema.String(resourceType)
+
+	if def, ok := lookup(name); ok {
+		ws.addLinks(def, result, workflowID, runID)
+	}
+
+	return result, info.GetStatus() != enums.WORKFLOW_EXECUTION_STATUS_RUNNING, nil
+}
 
-	return result
+// queryState asks a workflow run for its current state
+func queryState(ctx context.Context, c client.Client, workflowID, runID string) (*schema.WorkflowInstanceUpdate, error) {
+	resp, err := c.QueryWorkflow(ctx, workflowID, runID, "state")
+	if err != nil {
+		return nil, err
+	}
+	result := &schema.WorkflowInstanceUpdate{}
+	if err := resp.Get(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reportedStatus returns the status a running workflow tracks itself, like Created before it starts its work or
+// Failed while its saga compensates. Closed workflows, and runs that couldn't be queried, report the status of the
+// Temporal execution.
+func reportedStatus(tracked schema.WorkflowInstanceStatus, status enums.WorkflowExecutionStatus) schema.WorkflowInstanceStatus {
+	if status == enums.WORKFLOW_EXECUTION_STATUS_RUNNING && tracked != "" {
+		return tracked
+	}
+	return instanceStatus(status)
+}
+
+// instanceStatus maps the status of a Temporal workflow execution to the status of a Dispatch workflow instance
+func instanceStatus(status enums.WorkflowExecutionStatus) schema.WorkflowInstanceStatus {
+	switch status {
+	case enums.WORKFLOW_EXECUTION_STATUS_RUNNING, enums.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW:
+		return schema.WorkflowInstanceStatusRunning
+	case enums.WORKFLOW_EXECUTION_STATUS_COMPLETED:
+		return schema.WorkflowInstanceStatusCompleted
+	case enums.WORKFLOW_EXECUTION_STATUS_FAILED,
+		enums.WORKFLOW_EXECUTION_STATUS_CANCELED,
+		enums.WORKFLOW_EXECUTION_STATUS_TERMINATED,
+		enums.WORKFLOW_EXECUTION_STATUS_TIMED_OUT:
+		return schema.WorkflowInstanceStatusFailed
+	default:
+		return schema.WorkflowInstanceStatusSubmitted
+	}
 }
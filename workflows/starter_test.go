@@ -0,0 +1,239 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/artifacts"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	commonpb "go.temporal.io/api/common/v1"
+	enums "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"net/url"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestInstanceStatus(t *testing.T) {
+	tests := []struct {
+		status enums.WorkflowExecutionStatus
+		want   schema.WorkflowInstanceStatus
+	}{
+		{enums.WORKFLOW_EXECUTION_STATUS_UNSPECIFIED, schema.WorkflowInstanceStatusSubmitted},
+		{enums.WORKFLOW_EXECUTION_STATUS_RUNNING, schema.WorkflowInstanceStatusRunning},
+		{enums.WORKFLOW_EXECUTION_STATUS_CONTINUED_AS_NEW, schema.WorkflowInstanceStatusRunning},
+		{enums.WORKFLOW_EXECUTION_STATUS_COMPLETED, schema.WorkflowInstanceStatusCompleted},
+		{enums.WORKFLOW_EXECUTION_STATUS_FAILED, schema.WorkflowInstanceStatusFailed},
+		{enums.WORKFLOW_EXECUTION_STATUS_CANCELED, schema.WorkflowInstanceStatusFailed},
+		{enums.WORKFLOW_EXECUTION_STATUS_TERMINATED, schema.WorkflowInstanceStatusFailed},
+		{enums.WORKFLOW_EXECUTION_STATUS_TIMED_OUT, schema.WorkflowInstanceStatusFailed},
+	}
+	for _, test := range tests {
+		if got := instanceStatus(test.status); got != test.want {
+			t.Errorf("status of %s is %s, want %s", test.status, got, test.want)
+		}
+	}
+}
+
+func TestReportedStatus(t *testing.T) {
+	tests := []struct {
+		tracked schema.WorkflowInstanceStatus
+		status  enums.WorkflowExecutionStatus
+		want    schema.WorkflowInstanceStatus
+	}{
+		{schema.WorkflowInstanceStatusCreated, enums.WORKFLOW_EXECUTION_STATUS_RUNNING, schema.WorkflowInstanceStatusCreated},
+		{schema.WorkflowInstanceStatusSubmitted, enums.WORKFLOW_EXECUTION_STATUS_RUNNING, schema.WorkflowInstanceStatusSubmitted},
+		{schema.WorkflowInstanceStatusFailed, enums.WORKFLOW_EXECUTION_STATUS_RUNNING, schema.WorkflowInstanceStatusFailed},
+		// the query failed
+		{"", enums.WORKFLOW_EXECUTION_STATUS_RUNNING, schema.WorkflowInstanceStatusRunning},
+		// a closed workflow reports the outcome of the execution, not the last state it tracked
+		{schema.WorkflowInstanceStatusRunning, enums.WORKFLOW_EXECUTION_STATUS_CANCELED, schema.WorkflowInstanceStatusFailed},
+		{schema.WorkflowInstanceStatusRunning, enums.WORKFLOW_EXECUTION_STATUS_TERMINATED, schema.WorkflowInstanceStatusFailed},
+		{schema.WorkflowInstanceStatusCompleted, enums.WORKFLOW_EXECUTION_STATUS_COMPLETED, schema.WorkflowInstanceStatusCompleted},
+	}
+	for _, test := range tests {
+		if got := reportedStatus(test.tracked, test.status); got != test.want {
+			t.Errorf("status of %s tracking %q is %s, want %s", test.status, test.tracked, got, test.want)
+		}
+	}
+}
+
+func TestNotFoundErr(t *testing.T) {
+	// errors other than not found are returned as is, the API answers them with 500
+	internal := serviceerror.NewInternal("boom")
+	other := errors.New("connection refused")
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{nil, nil},
+		{serviceerror.NewNotFound("workflow execution not found"), ErrWorkflowNotFound},
+		{fmt.Errorf("describe: %w", serviceerror.NewNotFound("workflow execution not found")), ErrWorkflowNotFound},
+		{internal, internal},
+		{other, other},
+	}
+	for _, test := range tests {
+		if got := notFoundErr(test.err); got != test.want {
+			t.Errorf("notFoundErr(%v) is %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestDefinitionLink(t *testing.T) {
+	data := LinkData{WebURL: "http://temporal:8088", Namespace: "default", WorkflowID: "random_dog-25", RunID: "run-1"}
+	tests := []struct {
+		weblink string
+		want    string
+		err     bool
+	}{
+		{weblink: DefaultWeblink, want: "http://temporal:8088/namespaces/default/workflows/random_dog-25/run-1/history"},
+		{weblink: "{{.WebURL}}/namespaces/{{.Namespace}}/workflows/{{.WorkflowID}}/{{.RunID}}/summary", want: "http://temporal:8088/namespaces/default/workflows/random_dog-25/run-1/summary"},
+		{weblink: "https://runbooks.example.com/{{.Name}}", want: "https://runbooks.example.com/random_dog"},
+		// text/template doesn't escape, the values are escaped by the caller
+		{weblink: "https://runbooks.example.com/?q={{.Name}}&a=1", want: "https://runbooks.example.com/?q=random_dog&a=1"},
+		{weblink: "{{.Missing}}", err: true},
+	}
+	for _, test := range tests {
+		def := &Definition{Name: "random_dog", weblink: template.Must(template.New("random_dog").Parse(test.weblink))}
+		got, err := def.link(data)
+		if (err != nil) != test.err {
+			t.Errorf("link of %q returned error %v", test.weblink, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("link of %q is %q, want %q", test.weblink, got, test.want)
+		}
+	}
+}
+
+func TestAddLinks(t *testing.T) {
+	ws := &WorkflowClient{webURL: "http://temporal:8088", namespace: "on call"}
+	def := &Definition{Name: "random_dog", weblink: template.Must(template.New("random_dog").Parse(DefaultWeblink))}
+	tests := []struct {
+		name       string
+		workflowID string
+		artifacts  []*schema.DocumentCreate
+		want       string
+		parameters int
+	}{
+		{
+			name:       "escaped",
+			workflowID: "random_dog/25 ?",
+			want:       "http://temporal:8088/namespaces/on%20call/workflows/random_dog%2F25%20%3F/run-1/history",
+		},
+		{
+			name:       "provenance",
+			workflowID: "random_dog-25",
+			artifacts:  []*schema.DocumentCreate{{Name: "dog.jpg", Description: schema.String("Dog 1")}},
+			want:       "http://temporal:8088/namespaces/on%20call/workflows/random_dog-25/run-1/history",
+			parameters: 1,
+		},
+	}
+	for _, test := range tests {
+		update := &schema.WorkflowInstanceUpdate{Artifacts: test.artifacts}
+		ws.addLinks(def, update, test.workflowID, "run-1")
+		if got := schema.StringValue(update.Weblink); got != test.want {
+			t.Errorf("%s: weblink is %q, want %q", test.name, got, test.want)
+		}
+		if len(update.Parameters) != test.parameters {
+			t.Errorf("%s: parameters are %v", test.name, update.Parameters)
+		}
+		for _, p := range update.Parameters {
+			if p["key"] != provenanceParameter || p["value"] != test.want {
+				t.Errorf("%s: provenance is %v", test.name, p)
+			}
+		}
+		for _, a := range update.Artifacts {
+			if d := schema.StringValue(a.Description); d != "Dog 1" {
+				t.Errorf("%s: the description of the artifact changed to %q", test.name, d)
+			}
+		}
+	}
+}
+
+func TestAddLinksSignsArtifacts(t *testing.T) {
+	links := &artifacts.Links{BaseURL: "http://td.example.com", Secret: []byte("secret")}
+	ws := &WorkflowClient{webURL: "http://temporal:8088", namespace: "default", env: Environment{Artifacts: &ArtifactStorage{Links: links}}}
+	def := &Definition{Name: "random_dog", weblink: template.Must(template.New("random_dog").Parse(DefaultWeblink))}
+	update := &schema.WorkflowInstanceUpdate{Artifacts: []*schema.DocumentCreate{
+		{Name: "report.txt", ResourceId: schema.String("random_dog-25/run-1/report.txt"), ResourceType: schema.String(artifactResourceType)},
+		{Name: "dog.jpg", Weblink: schema.String("https://images.dog.ceo/dog.jpg")},
+	}}
+	ws.addLinks(def, update, "random_dog-25", "run-1")
+
+	link, err := url.Parse(schema.StringValue(update.Artifacts[0].Weblink))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := links.Verify("random_dog-25/run-1/report.txt", link.Query()); err != nil {
+		t.Errorf("weblink %s is not a valid signed link: %v", link, err)
+	}
+	if got := schema.StringValue(update.Artifacts[1].Weblink); got != "https://images.dog.ceo/dog.jpg" {
+		t.Errorf("the weblink of an artifact outside the store changed to %q", got)
+	}
+}
+
+// queryClient answers the state query with the states in order, or with err when they ran out
+type queryClient struct {
+	client.Client
+	states  []*schema.WorkflowInstanceUpdate
+	err     error
+	queries int
+}
+
+func (c *queryClient) QueryWorkflow(ctx context.Context, workflowID, runID, queryType string, args ...interface{}) (converter.EncodedValue, error) {
+	c.queries++
+	if len(c.states) == 0 {
+		return nil, c.err
+	}
+	state := c.states[0]
+	c.states = c.states[1:]
+	payloads, err := converter.GetDefaultDataConverter().ToPayloads(state)
+	if err != nil {
+		return nil, err
+	}
+	return encodedState{payloads}, nil
+}
+
+type encodedState struct {
+	payloads *commonpb.Payloads
+}
+
+func (v encodedState) HasValue() bool { return true }
+
+func (v encodedState) Get(valuePtr interface{}) error {
+	return converter.GetDefaultDataConverter().FromPayloads(v.payloads, valuePtr)
+}
+
+func TestStartState(t *testing.T) {
+	running := &schema.WorkflowInstanceUpdate{Status: schema.WorkflowInstanceStatusRunning}
+	tests := []struct {
+		name    string
+		client  *queryClient
+		timeout time.Duration
+		want    schema.WorkflowInstanceStatus
+	}{
+		{name: "answered", client: &queryClient{states: []*schema.WorkflowInstanceUpdate{running}}, timeout: time.Second, want: schema.WorkflowInstanceStatusRunning},
+		// the worker didn't pick up the first workflow task yet
+		{name: "retried", client: &queryClient{states: []*schema.WorkflowInstanceUpdate{{}, running}}, timeout: time.Second, want: schema.WorkflowInstanceStatusRunning},
+		{name: "not answered", client: &queryClient{err: errors.New("no poller seen for task queue")}, timeout: 250 * time.Millisecond, want: schema.WorkflowInstanceStatusSubmitted},
+	}
+	for _, test := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+		started := time.Now()
+		got := (&WorkflowClient{}).startState(ctx, test.client, "random_dog-25", "run-1")
+		cancel()
+		if got.Status != test.want {
+			t.Errorf("%s: status is %s, want %s", test.name, got.Status, test.want)
+		}
+		if elapsed := time.Since(started); elapsed > test.timeout+100*time.Millisecond {
+			t.Errorf("%s: took %s, longer than the budget of %s", test.name, elapsed, test.timeout)
+		}
+		if test.want == schema.WorkflowInstanceStatusSubmitted && (got.Artifacts == nil || got.CreatedAt.IsZero()) {
+			t.Errorf("%s: fallback state is %+v", test.name, got)
+		}
+	}
+}
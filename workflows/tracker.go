@@ -0,0 +1,199 @@
+package workflows
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// invalidTransition is the error type of a workflow that tried to move to a status it can't move to
+	invalidTransition = "InvalidStatusTransition"
+	// continuedStateParam is the parameter that carries the state of a run to the run it continues as
+	continuedStateParam = "td_continued_state"
+	// continuedProgressParam is the parameter that carries the steps of a run to the run it continues as
+	continuedProgressParam = "td_continued_progress"
+)
+
+// Tracker keeps the Dispatch state of a workflow run. It serves the state to the "state" query, only allows
+// valid status transitions, stamps UpdatedAt with the workflow clock and publishes every change to the
+// callback URL when callbacks are enabled.
+type Tracker struct {
+	update   *schema.WorkflowInstanceUpdate
+	pub      *publisher
+	progress *Progress
+	current  *Step
+}
+
+// NewTracker returns a tracker for the workflow run in the Created status and registers the "state" query. The
+// code version of the run is reported as parameter.
+//
+// When the run continues a run that called ContinueAsNew, the tracker resumes with the state and the steps of that
+// run instead. The carried state is removed from params, so it isn't passed on to child workflows.
+func NewTracker(ctx workflow.Context, params map[string]interface{}) (*Tracker, error) {
+	now := workflow.Now(ctx).UTC()
+	t := &Tracker{
+		update: &schema.WorkflowInstanceUpdate{
+			Artifacts:    []*schema.DocumentCreate{},
+			CreatedAt:    now,
+			ResourceId:   schema.String(workflow.GetInfo(ctx).WorkflowExecution.RunID),
+			ResourceType: schema.String(resourceType),
+			Status:       schema.WorkflowInstanceStatusCreated,
+			UpdatedAt:    now,
+		},
+		pub:      newPublisher(ctx, params),
+		progress: &Progress{Steps: []*Step{}},
+	}
+	if state, ok := params[continuedStateParam]; ok {
+		delete(params, continuedStateParam)
+		if err := decodeState(state, t.update); err != nil {
+			return nil, temporal.NewNonRetryableApplicationError("unable to resume the state of the previous run", "InvalidContinuedState", err)
+		}
+	}
+	if progress, ok := params[continuedProgressParam]; ok {
+		delete(params, continuedProgressParam)
+		if err := decodeState(progress, t.progress); err != nil {
+			return nil, temporal.NewNonRetryableApplicationError("unable to resume the steps of the previous run", "InvalidContinuedState", err)
+		}
+		// the step that was in progress when the run continued as new goes on in this run
+		for _, step := range t.progress.Steps {
+			if step.Started != nil && step.Finished == nil {
+				t.current = step
+			}
+		}
+	}
+	if version := recordCodeVersion(ctx); version != "" {
+		t.setParameter(codeVersionParameter, version)
+	}
+
+	err := workflow.SetQueryHandler(ctx, "state", func() (*schema.WorkflowInstanceUpdate, error) {
+		return t.update, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = workflow.SetQueryHandler(ctx, "progress", func() (*Progress, error) {
+		return t.progress, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Update returns the current state of the workflow run
+func (t *Tracker) Update() *schema.WorkflowInstanceUpdate {
+	return t.update
+}
+
+// Transition moves the workflow run to the next status. Moving to the current status only updates the
+// timestamp, an invalid transition returns a non-retryable error and leaves the state untouched.
+func (t *Tracker) Transition(ctx workflow.Context, status schema.WorkflowInstanceStatus) error {
+	if status != t.update.Status && !t.update.Status.CanTransition(status) {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("workflow can't move from %s to %s", t.update.Status, status), invalidTransition, nil)
+	}
+	if status == schema.WorkflowInstanceStatusCompleted && len(t.progress.Steps) > 0 {
+		t.finishStep(ctx, nil)
+		t.update.RunReason = schema.String(fmt.Sprintf("Completed %d steps", len(t.progress.Steps)))
+	}
+	t.update.Status = status
+	t.changed(ctx)
+	return nil
+}
+
+// AddArtifact adds an artifact to the workflow run
+func (t *Tracker) AddArtifact(ctx workflow.Context, artifact *schema.DocumentCreate) {
+	t.update.Artifacts = append(t.update.Artifacts, artifact)
+	t.changed(ctx)
+}
+
+// Fail moves the workflow run to Failed with the error as run reason and returns the error, so workflows can
+// return t.Fail(ctx, err)
+func (t *Tracker) Fail(ctx workflow.Context, err error) error {
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+	t.fail(ctx, reason)
+	return err
+}
+
+// fail moves the workflow run to Failed with the reason, unless the run already finished
+func (t *Tracker) fail(ctx workflow.Context, reason string) {
+	if t.update.Status.Final() {
+		return
+	}
+	t.finishStep(ctx, errors.New(reason))
+	t.update.Status = schema.WorkflowInstanceStatusFailed
+	if reason != "" {
+		t.update.RunReason = schema.String(reason)
+	}
+	t.changed(ctx)
+}
+
+// ContinueAsNew returns the error that continues the workflow as a new run with params, to keep the history of
+// long-running workflows small. The state and the steps are carried to the new run, where NewTracker resumes them,
+// so the "state" and "progress" queries and callbacks continue where this run left off. The new run doesn't plan
+// the steps again. Workflows that take other arguments than params carry
+// the state with Carry and return workflow.NewContinueAsNewError themselves, after they waited for the callbacks
+// with flush.
+func (t *Tracker) ContinueAsNew(ctx workflow.Context, workflowFn interface{}, params map[string]interface{}) error {
+	t.flush(ctx)
+	return workflow.NewContinueAsNewError(ctx, workflowFn, t.Carry(params))
+}
+
+// Carry returns a copy of params with the state and the steps of the run, for the run the workflow continues as
+func (t *Tracker) Carry(params map[string]interface{}) map[string]interface{} {
+	carried := make(map[string]interface{}, len(params)+2)
+	for k, v := range params {
+		carried[k] = v
+	}
+	carried[continuedStateParam] = t.update
+	carried[continuedProgressParam] = t.progress
+	return carried
+}
+
+// setParameter sets the value of a parameter reported to Dispatch, replacing the value of an existing key
+func (t *Tracker) setParameter(key string, value interface{}) {
+	setParameter(t.update, key, value)
+}
+
+// setParameter sets the value of a parameter of the update, replacing the value of an existing key
+func setParameter(update *schema.WorkflowInstanceUpdate, key string, value interface{}) {
+	for _, p := range update.Parameters {
+		if p["key"] == key {
+			p["value"] = value
+			return
+		}
+	}
+	update.Parameters = append(update.Parameters, map[string]interface{}{"key": key, "value": value})
+}
+
+// decodeState decodes the carried state or steps into v, they are a generic map after they passed the data
+// converter
+func decodeState(state interface{}, v interface{}) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// changed publishes the state. When the run reached a final status, it waits until the callbacks were sent, so
+// Dispatch learns about the final state before the workflow closes.
+func (t *Tracker) changed(ctx workflow.Context) {
+	t.update.UpdatedAt = workflow.Now(ctx).UTC()
+	t.pub.publish(ctx, t.update)
+	if t.update.Status.Final() {
+		t.pub.flush(ctx)
+	}
+}
+
+// flush waits until the callbacks of all changes were sent
+func (t *Tracker) flush(ctx workflow.Context) {
+	t.pub.flush(ctx)
+}
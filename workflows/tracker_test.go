@@ -0,0 +1,78 @@
+package workflows_test
+
+import (
+	"errors"
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows/workflowtest"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/workflow"
+	"testing"
+	"time"
+)
+
+// roundsWorkflow adds an artifact per round and continues as new until the last round
+func roundsWorkflow(ctx workflow.Context, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+	tracker, err := workflows.NewTracker(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusRunning); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	round, _ := params["round"].(float64)
+	if round == 0 {
+		tracker.Plan("First round", "Second round")
+		tracker.Step(ctx, "First round")
+	} else {
+		tracker.Step(ctx, "Second round")
+	}
+	if err := workflow.Sleep(ctx, time.Minute); err != nil {
+		return nil, err
+	}
+	tracker.AddArtifact(ctx, workflowtest.Artifact("round.txt"))
+	if round == 0 {
+		params["round"] = round + 1
+		return nil, tracker.ContinueAsNew(ctx, roundsWorkflow, params)
+	}
+	if err := tracker.Transition(ctx, schema.WorkflowInstanceStatusCompleted); err != nil {
+		return nil, tracker.Fail(ctx, err)
+	}
+	return tracker.Update(), nil
+}
+
+func TestTrackerContinueAsNew(t *testing.T) {
+	env := workflowtest.New(t)
+	env.RegisterWorkflow(roundsWorkflow)
+	_, err := env.RunError(roundsWorkflow, workflowtest.Params(nil))
+	var continued *workflow.ContinueAsNewError
+	if !errors.As(err, &continued) {
+		t.Fatalf("expected the workflow to continue as new, got %v", err)
+	}
+	first := env.State()
+
+	var params map[string]interface{}
+	if err := converter.GetDefaultDataConverter().FromPayloads(continued.Input, &params); err != nil {
+		t.Fatal(err)
+	}
+	env = workflowtest.New(t)
+	env.RegisterWorkflow(roundsWorkflow)
+	env.At(30*time.Second, func(state *schema.WorkflowInstanceUpdate) {
+		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusRunning)
+		workflowtest.AssertArtifacts(t, state, "round.txt")
+		// the steps of the first run are carried over
+		progress := env.Progress()
+		if progress.Percent != 50 || len(progress.Steps) != 2 || progress.Steps[0].Finished == nil || progress.Steps[1].Started == nil {
+			t.Errorf("unexpected progress of the continued run %+v", progress)
+		}
+	})
+	result := env.Run(roundsWorkflow, params)
+	workflowtest.AssertStatus(t, result, schema.WorkflowInstanceStatusCompleted)
+	workflowtest.AssertArtifacts(t, result, "round.txt", "round.txt")
+	if progress := env.Progress(); progress.Percent != 100 || progress.Steps[1].Finished == nil {
+		t.Errorf("the step that continued isn't finished: %+v", progress)
+	}
+	if !result.CreatedAt.Equal(first.CreatedAt) || schema.StringValue(result.ResourceId) != schema.StringValue(first.ResourceId) {
+		t.Errorf("the continued run doesn't keep the creation of the first run: %v", result)
+	}
+}
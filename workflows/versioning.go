@@ -0,0 +1,45 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+)
+
+// Changes to a workflow must not change the commands that runs in flight already recorded in their history,
+// like timers, activities and side effects, or those runs fail with a non-determinism error when they are
+// replayed on the new code. The convention for a change is:
+//
+//  1. guard the new code with Changed and keep the old code in the else branch
+//  2. bump Version of the definition, so Dispatch shows which revision of the runbook ran
+//  3. record a history of the new code with td history export for the replay tests
+//  4. remove the old branch once no run that started before the change is open anymore, but keep the Changed
+//     call as long as histories with the change id exist
+//
+// Change ids are named after the workflow and the change, like "random_dog-shorter-timer".
+
+// Changed reports whether the run executes the new code of the change. Runs that already passed this point
+// before the change was deployed keep taking the old path, new runs take the new path.
+func Changed(ctx workflow.Context, changeID string) bool {
+	return workflow.GetVersion(ctx, changeID, workflow.DefaultVersion, 1) == 1
+}
+
+// codeVersionParameter is the key of the Dispatch parameter with the code version of a run
+const codeVersionParameter = "code_version"
+
+// recordCodeVersion records the version of the definition of the running workflow in the history, so the
+// run keeps reporting the version it started with when a worker with newer code picks it up
+func recordCodeVersion(ctx workflow.Context) string {
+	if !Changed(ctx, "record-code-version") {
+		return ""
+	}
+	var version string
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		if def, ok := lookupByType(workflow.GetInfo(ctx).WorkflowType.Name); ok {
+			return def.Version
+		}
+		return ""
+	}).Get(&version)
+	if err != nil {
+		workflow.GetLogger(ctx).Warn("Unable to record code version", "Error", err)
+	}
+	return version
+}
@@ -0,0 +1,145 @@
+package workflowtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DogAPI is a fake of the dog.ceo API. The images it returns are served by the same server.
+type DogAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests int
+	failure  string
+}
+
+// NewDogAPI starts a fake dog.ceo API. Close it when done.
+func NewDogAPI() *DogAPI {
+	d := &DogAPI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/breeds/image/random", d.random)
+	mux.HandleFunc("/breeds/", func(w http.ResponseWriter, r *http.Request) {
+		serveJPEG(w, 500, 375)
+	})
+	d.Server = httptest.NewServer(mux)
+	return d
+}
+
+// BaseURL is the base URL to configure DogActivities with
+func (d *DogAPI) BaseURL() string {
+	return d.URL + "/api"
+}
+
+// Requests returns the number of random images requested
+func (d *DogAPI) Requests() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requests
+}
+
+// Fail makes the API answer with an error status and the message. An empty message restores it.
+func (d *DogAPI) Fail(message string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failure = message
+}
+
+func (d *DogAPI) random(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	d.requests++
+	n, failure := d.requests, d.failure
+	d.mu.Unlock()
+
+	w.Header().Set("content-type", "application/json")
+	if failure != "" {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"status": "error", "message": failure})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": fmt.Sprintf("%s/breeds/chihuahua/n02085620_%d.jpg", d.URL, n),
+	})
+}
+
+// Unsplash is a fake of Unsplash Source. A random photo redirects to a photo served by the same server, in
+// the size that was asked for.
+type Unsplash struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	terms []string
+}
+
+// NewUnsplash starts a fake Unsplash Source. Close it when done.
+func NewUnsplash() *Unsplash {
+	u := &Unsplash{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/random/", u.random)
+	mux.HandleFunc("/photos/", func(w http.ResponseWriter, r *http.Request) {
+		width, _ := strconv.Atoi(r.URL.Query().Get("w"))
+		height, _ := strconv.Atoi(r.URL.Query().Get("h"))
+		serveJPEG(w, width, height)
+	})
+	u.Server = httptest.NewServer(mux)
+	return u
+}
+
+// BaseURL is the base URL to configure UnsplashActivities with
+func (u *Unsplash) BaseURL() string {
+	return u.URL
+}
+
+// Terms returns the search terms of the requested photos in order
+func (u *Unsplash) Terms() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.terms...)
+}
+
+func (u *Unsplash) random(w http.ResponseWriter, r *http.Request) {
+	var width, height int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/random/"), "%dx%d", &width, &height); err != nil {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+		return
+	}
+
+	u.mu.Lock()
+	u.terms = append(u.terms, r.URL.RawQuery)
+	n := len(u.terms)
+	u.mu.Unlock()
+
+	location := fmt.Sprintf("%s/photos/photo-%d?w=%d&h=%d", u.URL, n, width, height)
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusFound)
+}
+
+// serveJPEG writes a JPEG image of the size
+func serveJPEG(w http.ResponseWriter, width, height int) {
+	if width <= 0 || height <= 0 {
+		width, height = 400, 320
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "image/jpeg")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,83 @@
+package workflowtest
+
+import (
+	"bytes"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"go.temporal.io/sdk/worker"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// replayID is the workflow id the replayer runs every history under
+const replayID = "ReplayId"
+
+// Replay replays the recorded histories in dir against the code of the workflow definitions, to catch changes
+// that aren't deterministic before they break runs that are in flight. Histories are stored per workflow in
+// dir/<workflow name>/*.json, as written by td history export. Every definition runs as a subtest, which is
+// skipped when it has no recorded histories. Histories of workflows that aren't registered fail the test, as
+// runs of a removed workflow can't finish.
+func Replay(t *testing.T, dir string, defs []*workflows.Definition) {
+	known := map[string]bool{}
+	for _, def := range defs {
+		known[def.Name] = true
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unable to read histories: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !known[entry.Name()] {
+			t.Errorf("histories in %s belong to workflow %q that isn't registered", dir, entry.Name())
+		}
+	}
+
+	for _, def := range defs {
+		def := def
+		t.Run(def.Name, func(t *testing.T) {
+			files, err := filepath.Glob(filepath.Join(dir, def.Name, "*.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) == 0 {
+				t.Skipf("no recorded histories, record one with: td history export -workflow-id <id> -dir %s", dir)
+			}
+			for _, file := range files {
+				replayer := worker.NewWorkflowReplayer()
+				// child workflows of the run need to be registered as well
+				for _, d := range defs {
+					replayer.RegisterWorkflow(d.Workflow)
+				}
+				if err := replayer.ReplayWorkflowHistoryFromJSONFile(nil, replayable(t, file)); err != nil {
+					t.Errorf("%s: %v", filepath.Base(file), err)
+				}
+			}
+		})
+	}
+}
+
+// replayable returns the history file with the ids of the child workflows of the run rewritten for the replayer.
+// Children get the id of their parent with a suffix, but the replayer runs the parent as replayID, so the ids the
+// parent computes on replay wouldn't match the recorded ones. The id of the parent is taken from the file name.
+func replayable(t *testing.T, file string) string {
+	name := filepath.Base(file)
+	i := strings.LastIndex(name, "_")
+	if i <= 0 {
+		return file
+	}
+	history, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := []byte(`"` + name[:i] + "-")
+	if !bytes.Contains(history, parent) {
+		return file
+	}
+	rewritten := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(rewritten, bytes.ReplaceAll(history, parent, []byte(`"`+replayID+"-")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return rewritten
+}
@@ -0,0 +1,158 @@
+// Package workflowtest runs workflows that report their state with a workflows.Tracker in the Temporal test
+// environment. Timers are skipped, so a workflow that sleeps for minutes finishes right away, and the "state"
+// query can be checked at any point in workflow time:
+//
+//	env := workflowtest.New(t)
+//	env.OnActivity(FetchReportActivity, mock.Anything, mock.Anything).Return(workflowtest.Artifact("report.txt"), nil)
+//	env.At(time.Second, func(state *schema.WorkflowInstanceUpdate) {
+//		workflowtest.AssertStatus(t, state, schema.WorkflowInstanceStatusRunning)
+//	})
+//	result := env.Run(ReportWorkflow, workflowtest.Params(nil))
+package workflowtest
+
+import (
+	"github.com/jtorvald/temporal-dispatch-poc/schema"
+	"github.com/jtorvald/temporal-dispatch-poc/workflows"
+	"go.temporal.io/sdk/testsuite"
+	"testing"
+	"time"
+)
+
+// Env is a Temporal test environment with helpers for the Dispatch state of a run
+type Env struct {
+	*testsuite.TestWorkflowEnvironment
+	t      testing.TB
+	checks []*check
+}
+
+type check struct {
+	after time.Duration
+	ran   bool
+}
+
+// New returns a test environment that reports failures to t
+func New(t testing.TB) *Env {
+	var suite testsuite.WorkflowTestSuite
+	return &Env{TestWorkflowEnvironment: suite.NewTestWorkflowEnvironment(), t: t}
+}
+
+// State returns the result of the "state" query of the running workflow
+func (e *Env) State() *schema.WorkflowInstanceUpdate {
+	e.t.Helper()
+	value, err := e.QueryWorkflow("state")
+	if err != nil {
+		e.t.Fatalf("state query failed: %v", err)
+	}
+	var state *schema.WorkflowInstanceUpdate
+	if err := value.Get(&state); err != nil {
+		e.t.Fatalf("unable to decode state: %v", err)
+	}
+	return state
+}
+
+// Progress returns the result of the "progress" query of the running workflow
+func (e *Env) Progress() *workflows.Progress {
+	e.t.Helper()
+	value, err := e.QueryWorkflow("progress")
+	if err != nil {
+		e.t.Fatalf("progress query failed: %v", err)
+	}
+	var progress *workflows.Progress
+	if err := value.Get(&progress); err != nil {
+		e.t.Fatalf("unable to decode progress: %v", err)
+	}
+	return progress
+}
+
+// At calls fn with the state of the run after d of workflow time. It has to be called before Run. Run fails
+// the test when the workflow completed before d.
+func (e *Env) At(d time.Duration, fn func(state *schema.WorkflowInstanceUpdate)) {
+	c := &check{after: d}
+	e.checks = append(e.checks, c)
+	e.RegisterDelayedCallback(func() {
+		c.ran = true
+		fn(e.State())
+	}, d)
+}
+
+// Run executes the workflow and returns its result. It fails the test when the workflow returns an error.
+func (e *Env) Run(workflowFn interface{}, params map[string]interface{}) *schema.WorkflowInstanceUpdate {
+	e.t.Helper()
+	result, err := e.RunError(workflowFn, params)
+	if err != nil {
+		e.t.Fatalf("workflow failed: %v", err)
+	}
+	return result
+}
+
+// RunError executes the workflow and returns its result and error, for workflows that are expected to fail.
+// The result is the state of the run when the workflow returned no result.
+func (e *Env) RunError(workflowFn interface{}, params map[string]interface{}) (*schema.WorkflowInstanceUpdate, error) {
+	e.t.Helper()
+	e.ExecuteWorkflow(workflowFn, params)
+	if !e.IsWorkflowCompleted() {
+		e.t.Fatal("workflow did not complete")
+	}
+	for _, c := range e.checks {
+		if !c.ran {
+			e.t.Errorf("workflow completed before the check at %s", c.after)
+		}
+	}
+
+	var result *schema.WorkflowInstanceUpdate
+	err := e.GetWorkflowError()
+	if err == nil {
+		if err := e.GetWorkflowResult(&result); err != nil {
+			e.t.Fatalf("unable to decode result: %v", err)
+		}
+	}
+	if result == nil {
+		result = e.State()
+	}
+	return result, err
+}
+
+// Params returns the parameters Dispatch sends when it starts a workflow, merged with extra
+func Params(extra map[string]interface{}) map[string]interface{} {
+	params := map[string]interface{}{
+		"incident_id":   32,
+		"incident_name": "dispatch-default-default-32",
+		"instance_id":   25,
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	return params
+}
+
+// Artifact returns an artifact with the name as resource id, for activity mocks
+func Artifact(name string) *schema.DocumentCreate {
+	return &schema.DocumentCreate{
+		Name:       name,
+		ResourceId: schema.String(name),
+		Weblink:    schema.String("https://example.com/" + name),
+		Filters:    []*schema.SearchFilterRead{},
+	}
+}
+
+// AssertStatus fails the test when the state doesn't have the status
+func AssertStatus(t testing.TB, state *schema.WorkflowInstanceUpdate, status schema.WorkflowInstanceStatus) {
+	t.Helper()
+	if state.Status != status {
+		t.Errorf("status is %s, want %s", state.Status, status)
+	}
+}
+
+// AssertArtifacts fails the test when the state doesn't have artifacts with the names, in order
+func AssertArtifacts(t testing.TB, state *schema.WorkflowInstanceUpdate, names ...string) {
+	t.Helper()
+	if len(state.Artifacts) != len(names) {
+		t.Errorf("got %d artifacts, want %d", len(state.Artifacts), len(names))
+		return
+	}
+	for i, a := range state.Artifacts {
+		if a.Name != names[i] {
+			t.Errorf("artifact %d is %q, want %q", i, a.Name, names[i])
+		}
+	}
+}